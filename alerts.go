@@ -0,0 +1,336 @@
+package tipWifi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultAlertTemplate is the message used by the alert sinks when no Template is set.
+const DefaultAlertTemplate = `{{.SerialNumber}} {{.Type}}{{if .DeviceType}} ({{.DeviceType}}){{end}}{{if or .From .To}}: {{.From}} -> {{.To}}{{end}} at {{.Time.Format "2006-01-02 15:04:05 MST"}}`
+
+// alertTimeout bounds each delivery by a sink, as a Monitor sends Events to
+// its sinks in turn and a hung server would otherwise stall it.
+const alertTimeout = 30 * time.Second
+
+// ParseAlertTemplate parses a Go template that renders an Event as a message,
+// or the DefaultAlertTemplate if text is empty.
+func ParseAlertTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultAlertTemplate
+	}
+	return template.New("alert").Parse(text)
+}
+
+func alertMessage(tmpl *template.Template, e *Event) (string, error) {
+	var err error
+	if tmpl == nil {
+		tmpl, err = ParseAlertTemplate("")
+		if err != nil {
+			return "", err
+		}
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, e)
+	return buf.String(), err
+}
+
+// postJSON sends the value as a JSON POST request and fails on a non-2xx status.
+func postJSON(url string, v interface{}, headers map[string]string) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// The WebhookSink object posts each Event to a generic HTTP webhook as JSON,
+// with the Event under "event" and the rendered message under "message".
+type WebhookSink struct {
+	URL      string
+	Headers  map[string]string
+	Template *template.Template
+}
+
+// Send posts the Event to the webhook.
+func (ws *WebhookSink) Send(e *Event) error {
+	msg, err := alertMessage(ws.Template, e)
+	if err != nil {
+		return err
+	}
+	return postJSON(ws.URL, map[string]interface{}{
+		"event":   e,
+		"message": msg,
+	}, ws.Headers)
+}
+
+// The SlackSink object posts the rendered message of each Event to a
+// Slack-compatible incoming webhook.
+type SlackSink struct {
+	URL      string
+	Template *template.Template
+}
+
+// Send posts the Event's message to the incoming webhook.
+func (ss *SlackSink) Send(e *Event) error {
+	msg, err := alertMessage(ss.Template, e)
+	if err != nil {
+		return err
+	}
+	return postJSON(ss.URL, map[string]string{"text": msg}, nil)
+}
+
+// The EmailSink object mails each Event through an SMTP server. The message is
+// used as the subject and followed by the Event details in the body.
+// Authentication is only attempted when a Username is set.
+type EmailSink struct {
+	Addr     string // host:port of the SMTP server
+	Username string
+	Password string
+	From     string
+	To       []string
+	Template *template.Template
+}
+
+// Send mails the Event.
+func (es *EmailSink) Send(e *Event) error {
+	if len(es.To) < 1 {
+		return errors.New("No Email Recipients")
+	}
+	msg, err := alertMessage(es.Template, e)
+	if err != nil {
+		return err
+	}
+	details, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n\r\n%s\r\n",
+		es.From, strings.Join(es.To, ", "), subject, msg, details)
+	return es.send([]byte(body))
+}
+
+// send delivers the message as smtp.SendMail does, within the alertTimeout.
+func (es *EmailSink) send(msg []byte) error {
+	host, _, err := net.SplitHostPort(es.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", es.Addr, alertTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(alertTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if es.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", es.Username, es.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(es.From); err != nil {
+		return err
+	}
+	for _, to := range es.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// The AlertPolicy object wraps a Sink to reduce alert noise. Events pass through
+// the following stages in order:
+//
+//	Types      only these EventTypes are sent, all if empty
+//	FlapWindow a disconnect is held this long and dropped together with a
+//	           reconnect of the same device that arrives in the meantime
+//	Dedup      an Event identical to one sent within this window is dropped
+//	RateLimit  at most this many Events are sent per RatePeriod, def: 1h
+//
+// Events that complete their hold after Send has returned are delivered from a
+// timer, so their errors are reported to OnError.
+type AlertPolicy struct {
+	Sink       Sink
+	Types      []EventType
+	FlapWindow time.Duration
+	Dedup      time.Duration
+	RateLimit  int
+	RatePeriod time.Duration
+	OnError    func(err error)
+
+	mu         sync.Mutex
+	suppressed int
+	held       map[string]func() bool
+	lastKey    map[string]time.Time
+	sent       []time.Time
+
+	// the clock, replaced by tests
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+}
+
+// Suppressed returns the number of Events dropped by flap suppression,
+// deduplication or rate limiting.
+func (ap *AlertPolicy) Suppressed() int {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	return ap.suppressed
+}
+
+func (ap *AlertPolicy) clock() time.Time {
+	if ap.now != nil {
+		return ap.now()
+	}
+	return time.Now()
+}
+
+func (ap *AlertPolicy) after(d time.Duration, f func()) func() bool {
+	if ap.afterFunc != nil {
+		return ap.afterFunc(d, f)
+	}
+	return time.AfterFunc(d, f).Stop
+}
+
+// Send applies the AlertPolicy to the Event.
+func (ap *AlertPolicy) Send(e *Event) error {
+	if len(ap.Types) > 0 && !containsEventType(ap.Types, e.Type) {
+		return nil
+	}
+	if ap.FlapWindow > 0 {
+		ap.mu.Lock()
+		if ap.held == nil {
+			ap.held = make(map[string]func() bool)
+		}
+		if stop, ok := ap.held[e.SerialNumber]; ok && e.Type == EventConnected {
+			// the device came back within the window, neither event is sent
+			stop()
+			delete(ap.held, e.SerialNumber)
+			ap.suppressed += 2
+			ap.mu.Unlock()
+			return nil
+		}
+		if e.Type == EventDisconnected {
+			if _, ok := ap.held[e.SerialNumber]; !ok {
+				ap.held[e.SerialNumber] = ap.after(ap.FlapWindow, func() {
+					ap.mu.Lock()
+					delete(ap.held, e.SerialNumber)
+					ap.mu.Unlock()
+					if err := ap.deliver(e); err != nil && ap.OnError != nil {
+						ap.OnError(fmt.Errorf("%s %s: %s", e.SerialNumber, e.Type, err))
+					}
+				})
+			}
+			ap.mu.Unlock()
+			return nil
+		}
+		ap.mu.Unlock()
+	}
+	return ap.deliver(e)
+}
+
+// deliver applies deduplication and rate limiting before sending the Event.
+func (ap *AlertPolicy) deliver(e *Event) error {
+	now := ap.clock()
+	ap.mu.Lock()
+	if ap.Dedup > 0 {
+		if ap.lastKey == nil {
+			ap.lastKey = make(map[string]time.Time)
+		}
+		key := fmt.Sprintf("%s|%s|%s|%s", e.SerialNumber, e.Type, e.From, e.To)
+		if last, ok := ap.lastKey[key]; ok && now.Sub(last) < ap.Dedup {
+			ap.suppressed++
+			ap.mu.Unlock()
+			return nil
+		}
+		if len(ap.lastKey) > 1024 {
+			for k, last := range ap.lastKey {
+				if now.Sub(last) >= ap.Dedup {
+					delete(ap.lastKey, k)
+				}
+			}
+		}
+		ap.lastKey[key] = now
+	}
+	if ap.RateLimit > 0 {
+		period := ap.RatePeriod
+		if period <= 0 {
+			period = time.Hour
+		}
+		recent := ap.sent[:0]
+		for _, t := range ap.sent {
+			if now.Sub(t) < period {
+				recent = append(recent, t)
+			}
+		}
+		ap.sent = recent
+		if len(ap.sent) >= ap.RateLimit {
+			ap.suppressed++
+			ap.mu.Unlock()
+			return nil
+		}
+		ap.sent = append(ap.sent, now)
+	}
+	ap.mu.Unlock()
+	return ap.Sink.Send(e)
+}
+
+func containsEventType(list []EventType, t EventType) bool {
+	for _, v := range list {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
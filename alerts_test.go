@@ -0,0 +1,107 @@
+package tipWifi
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink collects the Events it is sent.
+type recordingSink struct {
+	mu   sync.Mutex
+	sent []*Event
+}
+
+func (rs *recordingSink) Send(e *Event) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.sent = append(rs.sent, e)
+	return nil
+}
+
+func (rs *recordingSink) count() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.sent)
+}
+
+// fakeClock stands in for the clock of an AlertPolicy, running the functions
+// held by afterFunc once advance passes their time.
+type fakeClock struct {
+	t     time.Time
+	timer []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (fc *fakeClock) now() time.Time {
+	return fc.t
+}
+
+func (fc *fakeClock) afterFunc(d time.Duration, f func()) func() bool {
+	ft := &fakeTimer{at: fc.t.Add(d), f: f}
+	fc.timer = append(fc.timer, ft)
+	return func() bool {
+		active := !ft.stopped
+		ft.stopped = true
+		return active
+	}
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.t = fc.t.Add(d)
+	for _, ft := range fc.timer {
+		if !ft.stopped && !ft.at.After(fc.t) {
+			ft.stopped = true
+			ft.f()
+		}
+	}
+}
+
+func TestAlertPolicySend(t *testing.T) {
+	down := &Event{SerialNumber: "aabbccddeeff", Type: EventDisconnected, From: "UP", To: "DOWN"}
+	up := &Event{SerialNumber: "aabbccddeeff", Type: EventConnected, From: "DOWN", To: "UP"}
+	fw := &Event{SerialNumber: "aabbccddeeff", Type: EventFirmwareChanged, From: "a", To: "b"}
+	other := &Event{SerialNumber: "112233445566", Type: EventFirmwareChanged, From: "a", To: "b"}
+	tests := []struct {
+		name       string
+		policy     *AlertPolicy
+		events     []*Event
+		advance    time.Duration // between events, releasing held ones
+		sent       int
+		suppressed int
+	}{
+		{"no policy", &AlertPolicy{}, []*Event{down, up, fw}, 0, 3, 0},
+		{"types", &AlertPolicy{Types: []EventType{EventFirmwareChanged}}, []*Event{down, up, fw}, 0, 1, 0},
+		{"flap within window", &AlertPolicy{FlapWindow: time.Minute}, []*Event{down, up}, 30 * time.Second, 0, 2},
+		{"disconnect outlasting window", &AlertPolicy{FlapWindow: time.Minute}, []*Event{down, up}, 2 * time.Minute, 2, 0},
+		{"dedup", &AlertPolicy{Dedup: time.Hour}, []*Event{fw, fw, other}, time.Minute, 2, 1},
+		{"dedup expired", &AlertPolicy{Dedup: time.Minute}, []*Event{fw, fw}, 2 * time.Minute, 2, 0},
+		{"rate limit", &AlertPolicy{RateLimit: 2}, []*Event{fw, other, fw}, time.Minute, 2, 1},
+		{"rate period passed", &AlertPolicy{RateLimit: 1, RatePeriod: time.Minute}, []*Event{fw, other}, 2 * time.Minute, 2, 0},
+	}
+	for _, tt := range tests {
+		rs := &recordingSink{}
+		fc := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+		ap := tt.policy
+		ap.Sink = rs
+		ap.now = fc.now
+		ap.afterFunc = fc.afterFunc
+		for _, e := range tt.events {
+			if err := ap.Send(e); err != nil {
+				t.Fatalf("%s: Send: %s", tt.name, err)
+			}
+			fc.advance(tt.advance)
+		}
+		if got := rs.count(); got != tt.sent {
+			t.Errorf("%s: sent %d event(s), want %d", tt.name, got, tt.sent)
+		}
+		if got := ap.Suppressed(); got != tt.suppressed {
+			t.Errorf("%s: suppressed %d event(s), want %d", tt.name, got, tt.suppressed)
+		}
+	}
+}
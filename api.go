@@ -2,11 +2,41 @@ package tipWifi
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 )
 
+// HTTPClient is used for every request to the UCentral endpoints.
+var HTTPClient = http.DefaultClient
+
+// SetCABundle makes HTTPClient trust the PEM encoded certificates in the supplied
+// file, for controllers whose certificates are signed by a private CA.
+func SetCABundle(file string) error {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("No Certificates Found in CA Bundle")
+	}
+	HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+	return nil
+}
+
 // GetRequest structures the HTTP "GET" call to the supplied endpoint.
 func GetRequest(oAuth *OAuth2, endpoint, uri string) (*http.Response, error) {
 	url := fmt.Sprintf("https://%s/api/v1/%s", endpoint, uri)
@@ -19,7 +49,7 @@ func GetRequest(oAuth *OAuth2, endpoint, uri string) (*http.Response, error) {
 	if Debug {
 		fmt.Printf("|-| GET %s |-|\n", url)
 	}
-	return http.DefaultClient.Do(req)
+	return HTTPClient.Do(req)
 }
 
 // PostRequest structures the HTTP "POST" call to the supplied endpoint.
@@ -41,7 +71,7 @@ func PostRequest(oAuth *OAuth2, endpoint, uri string, data []byte) (*http.Respon
 	if Debug {
 		fmt.Printf("|-| POST %s |-|\n", url)
 	}
-	return http.DefaultClient.Do(req)
+	return HTTPClient.Do(req)
 }
 
 // PutRequest structures the HTTP "PUT" call to the supplied endpoint.
@@ -60,7 +90,7 @@ func PutRequest(oAuth *OAuth2, endpoint, uri string, data []byte) (*http.Respons
 	if Debug {
 		fmt.Printf("|-| PUT %s |-|\n", url)
 	}
-	return http.DefaultClient.Do(req)
+	return HTTPClient.Do(req)
 }
 
 // DeleteRequest structures the HTTP "DELETE" call to the supplied endpoint.
@@ -80,5 +110,5 @@ func DeleteRequest(oAuth *OAuth2, endpoint, uri string, data []byte) (*http.Resp
 	if Debug {
 		fmt.Printf("|-| DELETE %s |-|\n", url)
 	}
-	return http.DefaultClient.Do(req)
+	return HTTPClient.Do(req)
 }
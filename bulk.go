@@ -0,0 +1,262 @@
+package tipWifi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bulk status values of a BulkResult.
+const (
+	BulkOK        = "ok"
+	BulkFailed    = "failed"
+	BulkTimeout   = "timeout"
+	BulkCancelled = "cancelled"
+)
+
+// A BulkOperation is run once per device by RunBulk and returns a short
+// description of what it did.
+type BulkOperation func(sn string) (string, error)
+
+// The BulkOptions object controls how RunBulk executes a BulkOperation.
+type BulkOptions struct {
+	Workers   int             // devices operated on at once, def: 8
+	Timeout   time.Duration   // per device, 0 for none
+	Context   context.Context // stops dispatching devices when done, def: context.Background()
+	Interrupt bool            // also stop on Ctrl-C, a second Ctrl-C exits as usual
+	Progress  io.Writer       // receives a live progress line, nil for none
+}
+
+// The BulkResult object records the outcome of a BulkOperation on one device.
+type BulkResult struct {
+	SerialNumber string
+	Status       string
+	Result       string
+	Error        string
+	Duration     time.Duration
+}
+
+// The BulkResults object collects the BulkResult of every device in the order given to RunBulk.
+type BulkResults struct {
+	Operation string
+	Entry     []*BulkResult
+}
+
+// RunBulk runs the operation on every device with a bounded worker pool. A
+// device that exceeds the Timeout is recorded as timed out while its request
+// completes in the background, as the UCentral calls cannot be aborted. Once
+// the Context is done, devices not yet finished are recorded as cancelled.
+func RunBulk(name string, sns []string, opts *BulkOptions, op BulkOperation) *BulkResults {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 8
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.Interrupt {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		go func() {
+			// restore the default behaviour so that a second Ctrl-C exits
+			<-ctx.Done()
+			stop()
+		}()
+	}
+
+	br := &BulkResults{Operation: name}
+	for _, sn := range sns {
+		br.Entry = append(br.Entry, &BulkResult{SerialNumber: sn})
+	}
+	p := &bulkProgress{w: opts.Progress, total: len(sns)}
+	jobs := make(chan *BulkResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(sns); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				if ctx.Err() != nil {
+					// left unset, recorded as cancelled below
+					continue
+				}
+				p.start()
+				runBulkOne(ctx, r, opts.Timeout, op)
+				p.done(r)
+			}
+		}()
+	}
+dispatch:
+	for _, r := range br.Entry {
+		// select picks at random when both are ready, so check first
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- r:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	for _, r := range br.Entry {
+		if r.Status == "" {
+			r.Status = BulkCancelled
+		}
+	}
+	p.finish()
+	return br
+}
+
+func runBulkOne(ctx context.Context, r *BulkResult, timeout time.Duration, op BulkOperation) {
+	type outcome struct {
+		result string
+		err    error
+	}
+	started := time.Now()
+	if ctx.Err() != nil {
+		r.Status = BulkCancelled
+		r.Error = "Cancelled before it was started"
+		return
+	}
+	ch := make(chan outcome, 1)
+	go func() {
+		res, err := op(r.SerialNumber)
+		ch <- outcome{res, err}
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case o := <-ch:
+		r.Status = BulkOK
+		r.Result = o.result
+		if o.err != nil {
+			r.Status = BulkFailed
+			r.Error = o.err.Error()
+		}
+	case <-expired:
+		r.Status = BulkTimeout
+		r.Error = fmt.Sprintf("No result after %s", timeout)
+	case <-ctx.Done():
+		r.Status = BulkCancelled
+		r.Error = "Cancelled before a result was received"
+	}
+	r.Duration = time.Since(started).Round(time.Millisecond)
+}
+
+// The bulkProgress object redraws a single progress line as devices complete.
+type bulkProgress struct {
+	mu      sync.Mutex
+	w       io.Writer
+	total   int
+	running int
+	counts  map[string]int
+	last    string
+}
+
+func (p *bulkProgress) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running++
+	p.draw()
+}
+
+func (p *bulkProgress) done(r *BulkResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.counts == nil {
+		p.counts = make(map[string]int)
+	}
+	p.running--
+	p.counts[r.Status]++
+	p.last = r.SerialNumber + " " + r.Status
+	p.draw()
+}
+
+func (p *bulkProgress) draw() {
+	if p.w == nil {
+		return
+	}
+	finished := 0
+	for _, n := range p.counts {
+		finished += n
+	}
+	fmt.Fprintf(p.w, "\r\033[K[%d/%d] ok: %d, failed: %d, running: %d", finished, p.total, p.counts[BulkOK], finished-p.counts[BulkOK], p.running)
+	if p.last != "" {
+		fmt.Fprintf(p.w, ", last: %s", p.last)
+	}
+}
+
+func (p *bulkProgress) finish() {
+	if p.w != nil && p.total > 0 {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// Summary returns the number of devices in each status.
+func (br *BulkResults) Summary() map[string]int {
+	sum := make(map[string]int)
+	for i := 0; i < len(br.Entry); i++ {
+		sum[br.Entry[i].Status]++
+	}
+	return sum
+}
+
+// Failed returns the Serial Numbers of every device that did not succeed.
+func (br *BulkResults) Failed() (list []string) {
+	for i := 0; i < len(br.Entry); i++ {
+		if br.Entry[i].Status != BulkOK {
+			list = append(list, br.Entry[i].SerialNumber)
+		}
+	}
+	return list
+}
+
+// Err returns an error describing how many devices did not succeed, or nil.
+func (br *BulkResults) Err() error {
+	failed := len(br.Failed())
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s failed on %d of %d device(s)", br.Operation, failed, len(br.Entry))
+}
+
+// WriteFailed writes the Serial Numbers of failed devices to a file, one per
+// line, which can be retried with the selector @file.
+func (br *BulkResults) WriteFailed(file string) error {
+	failed := br.Failed()
+	if len(failed) < 1 {
+		return errors.New("No failed devices")
+	}
+	data := fmt.Sprintf("# %s failed on %s\n", br.Operation, time.Now().Format(time.RFC3339))
+	data += strings.Join(failed, "\n") + "\n"
+	return ioutil.WriteFile(file, []byte(data), 0644)
+}
+
+// Report returns a Report with the outcome of each device.
+func (br *BulkResults) Report() *Report {
+	sum := br.Summary()
+	title := fmt.Sprintf("%s: ok %d, failed %d, timeout %d, cancelled %d", br.Operation, sum[BulkOK], sum[BulkFailed], sum[BulkTimeout], sum[BulkCancelled])
+	r := NewReport(title, "serialNumber", "status", "result", "error", "duration")
+	for _, e := range br.Entry {
+		r.Add(e.SerialNumber, e.Status, e.Result, e.Error, e.Duration.String())
+	}
+	return r
+}
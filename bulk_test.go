@@ -0,0 +1,57 @@
+package tipWifi
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBulk(t *testing.T) {
+	sns := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	var calls int32
+	op := func(sn string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return sn, nil
+	}
+	br := RunBulk("test", sns, &BulkOptions{Workers: 3}, op)
+	if got := br.Summary()[BulkOK]; got != len(sns) {
+		t.Errorf("ok = %d, want %d", got, len(sns))
+	}
+	if calls != int32(len(sns)) {
+		t.Errorf("op called %d times, want %d", calls, len(sns))
+	}
+}
+
+func TestRunBulkCancelled(t *testing.T) {
+	sns := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for n := 0; n < 50; n++ {
+		var calls int32
+		op := func(sn string) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			return sn, nil
+		}
+		br := RunBulk("test", sns, &BulkOptions{Workers: 4, Context: ctx}, op)
+		if calls != 0 {
+			t.Fatalf("op called %d times with a cancelled context", calls)
+		}
+		if got := br.Summary()[BulkCancelled]; got != len(sns) {
+			t.Fatalf("cancelled = %d, want %d", got, len(sns))
+		}
+	}
+}
+
+func TestRunBulkTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	op := func(sn string) (string, error) {
+		<-block
+		return sn, nil
+	}
+	br := RunBulk("test", []string{"a"}, &BulkOptions{Timeout: 10 * time.Millisecond}, op)
+	if br.Entry[0].Status != BulkTimeout {
+		t.Errorf("status = %q, want %q", br.Entry[0].Status, BulkTimeout)
+	}
+}
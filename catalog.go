@@ -0,0 +1,248 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCatalogTTL is how long a cached DeviceCatalog is used before it is fetched again.
+var DefaultCatalogTTL = 24 * time.Hour
+
+// The DeviceCatalog object is the list of device types known to the FMS, enriched
+// with the Compatible string and hardware Capabilities reported by a device of
+// each type registered on the GW. It replaces a static list of device types and
+// is cached on disk between runs.
+type DeviceCatalog struct {
+	Fetched int               `json:"fetched"`
+	Entry   []*DeviceTypeInfo `json:"deviceTypes"`
+}
+
+// The DeviceTypeInfo object describes a single entry of the DeviceCatalog.
+// Compatible and Capabilities are only known once a device of the type has
+// been registered on the GW.
+type DeviceTypeInfo struct {
+	DeviceType   string        `json:"deviceType"`
+	Compatible   string        `json:"compatible,omitempty"`
+	Devices      int           `json:"devices"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+}
+
+// The Capabilities object represents the hardware description a device reports to the GW.
+type Capabilities struct {
+	Compatible string                     `json:"compatible"`
+	Model      string                     `json:"model"`
+	Platform   string                     `json:"platform"`
+	Network    map[string][]string        `json:"network,omitempty"` // logical port names by role, ex: "lan": ["eth1","eth2"]
+	Wifi       map[string]*WifiCapability `json:"wifi,omitempty"`    // keyed by radio phy path
+}
+
+// The WifiCapability object describes a single radio phy of a device.
+type WifiCapability struct {
+	Band       []string `json:"band"`
+	Channels   []int    `json:"channels,omitempty"`
+	TxAntennas int      `json:"tx_ant,omitempty"`
+	RxAntennas int      `json:"rx_ant,omitempty"`
+	HtCapa     int      `json:"ht_capa,omitempty"`
+	VhtCapa    int      `json:"vht_capa,omitempty"`
+}
+
+// The DeviceCapabilities object wraps the Capabilities returned from the GW.
+type DeviceCapabilities struct {
+	SerialNumber string        `json:"serialNumber"`
+	FirstUpdate  int           `json:"firstUpdate"`
+	LastUpdate   int           `json:"lastUpdate"`
+	Capabilities *Capabilities `json:"capabilities"`
+}
+
+// DefaultCatalogPath returns the location of the DeviceCatalog cache file
+// within the user's cache directory.
+func DefaultCatalogPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tipwifi", "devicetypes.json")
+}
+
+// LoadCatalog reads a cached DeviceCatalog from the supplied path.
+func LoadCatalog(path string) (*DeviceCatalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cat := &DeviceCatalog{}
+	err = json.Unmarshal(data, &cat)
+	if err != nil {
+		return nil, err
+	}
+	return cat, nil
+}
+
+// Save writes the DeviceCatalog to the supplied path.
+func (cat *DeviceCatalog) Save(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cat, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Expired returns whether the DeviceCatalog is older than the supplied TTL.
+func (cat *DeviceCatalog) Expired(ttl time.Duration) bool {
+	return time.Since(time.Unix(int64(cat.Fetched), 0)) > ttl
+}
+
+// GetDeviceCatalog returns the DeviceCatalog cached at path if it is younger than
+// the TTL, and otherwise fetches a new one and caches it. If fetching fails an
+// expired cache is returned rather than nothing.
+func (uc *UCentral) GetDeviceCatalog(path string, ttl time.Duration) (*DeviceCatalog, error) {
+	cached, cerr := LoadCatalog(path)
+	if cerr == nil && !cached.Expired(ttl) {
+		return cached, nil
+	}
+	cat, err := uc.FetchDeviceCatalog()
+	if err != nil {
+		if cerr == nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	err = cat.Save(path)
+	if err != nil && Debug {
+		fmt.Printf("|!| %s |!|\n", err)
+	}
+	return cat, nil
+}
+
+// FetchDeviceCatalog builds a DeviceCatalog from the FMS device set, adding the
+// Compatible string and Capabilities of the first GW device found for each type.
+func (uc *UCentral) FetchDeviceCatalog() (*DeviceCatalog, error) {
+	types, err := uc.ListFirmwareDeviceTypes()
+	if err != nil {
+		return nil, err
+	}
+	if len(types) < 1 {
+		return nil, errors.New("FMS returned no Device Types")
+	}
+	cat := &DeviceCatalog{Fetched: int(time.Now().Unix())}
+	for _, t := range types {
+		cat.Entry = append(cat.Entry, &DeviceTypeInfo{DeviceType: t})
+	}
+	sort.Slice(cat.Entry, func(a, b int) bool {
+		return cat.Entry[a].DeviceType < cat.Entry[b].DeviceType
+	})
+	devs, err := uc.ListDevices()
+	if err != nil {
+		// the GW enrichment is optional, the FMS types alone are still valid
+		return cat, nil
+	}
+	for i := 0; i < len(devs.Entry); i++ {
+		info := cat.Lookup(devs.Entry[i].DeviceType)
+		if info == nil {
+			continue
+		}
+		info.Devices++
+		if info.Compatible == "" {
+			info.Compatible = devs.Entry[i].Compatible
+		}
+		if info.Capabilities == nil {
+			caps, err := uc.GetDeviceCapabilities(devs.Entry[i].SerialNumber)
+			if err == nil {
+				info.Capabilities = caps.Capabilities
+			}
+		}
+	}
+	return cat, nil
+}
+
+// GetDeviceCapabilities returns the hardware Capabilities a device reported to the GW.
+func (uc *UCentral) GetDeviceCapabilities(sn string) (*DeviceCapabilities, error) {
+	resp, err := GetRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s/capabilities", sn))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+
+	caps := &DeviceCapabilities{}
+	err = json.Unmarshal(body, &caps)
+	if err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// DeviceTypes returns the device type names in the DeviceCatalog.
+func (cat *DeviceCatalog) DeviceTypes() (list []string) {
+	for i := 0; i < len(cat.Entry); i++ {
+		list = append(list, cat.Entry[i].DeviceType)
+	}
+	return list
+}
+
+// Lookup returns the DeviceTypeInfo for the supplied device type, or nil.
+func (cat *DeviceCatalog) Lookup(devType string) *DeviceTypeInfo {
+	for i := 0; i < len(cat.Entry); i++ {
+		if strings.EqualFold(cat.Entry[i].DeviceType, devType) {
+			return cat.Entry[i]
+		}
+	}
+	return nil
+}
+
+// Contains returns whether the supplied device type is valid for the FMS.
+func (cat *DeviceCatalog) Contains(devType string) bool {
+	return cat.Lookup(devType) != nil
+}
+
+// Report returns a Report with one row per DeviceTypeInfo.
+func (cat *DeviceCatalog) Report() *Report {
+	r := NewReport("Device Types", "deviceType", "compatible", "devices", "model", "platform", "radios")
+	for _, info := range cat.Entry {
+		var model, platform string
+		var radios int
+		if info.Capabilities != nil {
+			model = info.Capabilities.Model
+			platform = info.Capabilities.Platform
+			radios = len(info.Capabilities.Wifi)
+		}
+		r.Add(info.DeviceType, info.Compatible, info.Devices, model, platform, radios)
+	}
+	return r
+}
+
+// GenerateList returns a list of each DeviceTypeInfo's description.
+func (cat *DeviceCatalog) GenerateList() (list []string) {
+	for i := 0; i < len(cat.Entry); i++ {
+		list = append(list, cat.Entry[i].GenerateDescription())
+	}
+	return list
+}
+
+// GenerateDescription returns a string of concatenated values describing the DeviceTypeInfo object.
+func (info *DeviceTypeInfo) GenerateDescription() string {
+	desc := fmt.Sprintf("%s: ", info.DeviceType)
+	desc += fmt.Sprintf("Compatible: %s, ", info.Compatible)
+	desc += fmt.Sprintf("Devices: %d, ", info.Devices)
+	if info.Capabilities != nil {
+		desc += fmt.Sprintf("Model: %s, Platform: %s, Radios: %d, ", info.Capabilities.Model, info.Capabilities.Platform, len(info.Capabilities.Wifi))
+	}
+	return desc
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+// completionTTL is how long the completion cache is used before it is refreshed
+// from a cached session.
+const completionTTL = time.Hour
+
+var completionCmd = &command{
+	name:    "completion",
+	summary: "Print shell completion scripts and refresh their cache",
+	sub: []*command{
+		{
+			name:    "bash",
+			summary: "Print the bash completion script, ex: source <(tipwifi completion bash)",
+			offline: true,
+			run:     completionScript(bashCompletion),
+		},
+		{
+			name:    "zsh",
+			summary: "Print the zsh completion script, ex: source <(tipwifi completion zsh)",
+			offline: true,
+			run:     completionScript(zshCompletion),
+		},
+		{
+			name:    "fish",
+			summary: "Print the fish completion script, ex: tipwifi completion fish | source",
+			offline: true,
+			run:     completionScript(fishCompletion),
+		},
+		{
+			name:    "refresh",
+			summary: "Reload the Serial Numbers, Device Types and revisions offered by completion",
+			run:     completionRefresh,
+		},
+	},
+}
+
+// The scripts call the hidden __complete command with the words typed so far,
+// the last of which is the word being completed, and offer its output lines.
+const bashCompletion = `_tipwifi() {
+	local line="${COMP_LINE:0:COMP_POINT}" words cur
+	read -ra words <<< "$line"
+	[[ "$line" == *[[:space:]] ]] && words+=("")
+	local IFS=$'\n'
+	COMPREPLY=($(tipwifi __complete "${words[@]:1}" 2>/dev/null))
+	# bash splits words on =, so only the text after it is replaced
+	cur="${words[${#words[@]}-1]}"
+	if [[ "$cur" == *=* ]]; then
+		COMPREPLY=("${COMPREPLY[@]#"${cur%=*}="}")
+	fi
+}
+complete -o default -F _tipwifi tipwifi
+`
+
+const zshCompletion = `#compdef tipwifi
+_tipwifi() {
+	local -a candidates
+	candidates=("${(@f)$(tipwifi __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n "${candidates[1]}" ]]; then
+		compadd -Q -- "${candidates[@]}"
+	else
+		_files
+	fi
+}
+compdef _tipwifi tipwifi
+`
+
+const fishCompletion = `function __tipwifi_complete
+	set -l words (commandline -opc)
+	tipwifi __complete $words[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c tipwifi -a '(__tipwifi_complete)'
+`
+
+func completionScript(script string) func(ctx *context) error {
+	return func(ctx *context) error {
+		fmt.Print(script)
+		return nil
+	}
+}
+
+func completionRefresh(ctx *context) error {
+	cc, err := fetchCompletionCache(ctx.uc, completionProfile())
+	if err != nil {
+		return err
+	}
+	r := tipWifi.NewReport("Completion Cache", "serialNumbers", "deviceTypes", "revisions")
+	r.Add(len(cc.SerialNumbers), len(cc.DeviceTypes), len(cc.Revisions))
+	return ctx.render(r)
+}
+
+// The completionCache object holds the values offered for device arguments,
+// cached per profile so that completing a word does not need a login.
+type completionCache struct {
+	Fetched       int      `json:"fetched"`
+	SerialNumbers []string `json:"serialNumbers"`
+	DeviceTypes   []string `json:"deviceTypes"`
+	Revisions     []string `json:"revisions"`
+}
+
+func completionProfile() string {
+	_, p, err := connection()
+	if err != nil {
+		return "default"
+	}
+	return p.Name
+}
+
+func completionPath(name string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tipwifi", "completion", name+".json")
+}
+
+// loadCompletionCache reads the cache of a profile, returning an empty one if
+// it cannot be read.
+func loadCompletionCache(name string) *completionCache {
+	cc := &completionCache{}
+	data, err := ioutil.ReadFile(completionPath(name))
+	if err == nil {
+		json.Unmarshal(data, cc)
+	}
+	return cc
+}
+
+func (cc *completionCache) expired() bool {
+	return time.Since(time.Unix(int64(cc.Fetched), 0)) > completionTTL
+}
+
+// fetchCompletionCache populates the cache of a profile from ListDevices,
+// the DeviceCatalog and the revisions reported to the FMS.
+func fetchCompletionCache(uc *tipWifi.UCentral, name string) (*completionCache, error) {
+	cc := &completionCache{Fetched: int(time.Now().Unix())}
+	devs, err := uc.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(devs.Entry); i++ {
+		cc.SerialNumbers = append(cc.SerialNumbers, devs.Entry[i].SerialNumber)
+	}
+	cat, err := uc.GetDeviceCatalog(tipWifi.DefaultCatalogPath(), tipWifi.DefaultCatalogTTL)
+	if err != nil {
+		return nil, err
+	}
+	cc.DeviceTypes = cat.DeviceTypes()
+	fwds, err := uc.GetAllFirmwareDevices()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(fwds.Entry); i++ {
+		rev := fwds.Entry[i].Revision
+		if rev != "" && !seen[rev] {
+			seen[rev] = true
+			cc.Revisions = append(cc.Revisions, rev)
+		}
+	}
+	sort.Strings(cc.SerialNumbers)
+	sort.Strings(cc.DeviceTypes)
+	sort.Strings(cc.Revisions)
+
+	data, err := json.Marshal(cc)
+	if err != nil {
+		return nil, err
+	}
+	file := completionPath(name)
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return nil, err
+	}
+	return cc, ioutil.WriteFile(file, data, 0600)
+}
+
+// completeMain implements the hidden __complete command used by the completion
+// scripts. The last argument is the word being completed. An expired cache is
+// refreshed only when the profile has a cached session, as completion must
+// never prompt for a password.
+func completeMain(args []string) {
+	if len(args) < 1 {
+		return
+	}
+	words, prefix := args[:len(args)-1], args[len(args)-1]
+	list := candidates(words, prefix, func() *completionCache {
+		name := completionProfile()
+		cc := loadCompletionCache(name)
+		if cc.expired() {
+			uc, p, err := connection()
+			if err == nil && uc.RestoreSession(sessionPath(p)) == nil {
+				if fresh, err := fetchCompletionCache(uc, p.Name); err == nil {
+					cc = fresh
+				}
+			}
+		}
+		return cc
+	})
+	for _, c := range matchPrefix(list, prefix) {
+		fmt.Println(c)
+	}
+}
+
+// matchPrefix returns the candidates starting with prefix, ignoring case.
+func matchPrefix(list []string, prefix string) (out []string) {
+	for _, c := range list {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(prefix)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// candidates returns the words that may follow the supplied complete words:
+// subcommands of a group, flags and their values, or Serial Numbers, Device
+// Types and selector terms for a command's arguments. Global flags among the
+// words are applied so that completion uses the selected profile. The cache is
+// only loaded when device values are needed.
+func candidates(words []string, prefix string, cache func() *completionCache) []string {
+	cmd := commands
+	positional := 0
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if strings.HasPrefix(w, "-") && len(w) > 1 {
+			name := strings.TrimLeft(w, "-")
+			if strings.Contains(name, "=") {
+				continue
+			}
+			f := lookupFlag(cmd, name)
+			if f == nil || isBoolFlag(f) {
+				continue
+			}
+			if i+1 == len(words) {
+				return flagValues(f.Name)
+			}
+			if cmd == commands {
+				flag.Set(f.Name, words[i+1])
+			}
+			i++
+			continue
+		}
+		if cmd.run == nil {
+			if next := findCommand(cmd, w); next != nil {
+				cmd = next
+				continue
+			}
+		}
+		positional++
+	}
+
+	var list []string
+	if strings.HasPrefix(prefix, "-") {
+		visit := flag.CommandLine.VisitAll
+		if cmd != commands {
+			fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+			if cmd.flags != nil {
+				cmd.flags(fs)
+			}
+			visit = fs.VisitAll
+		}
+		visit(func(f *flag.Flag) {
+			list = append(list, "-"+f.Name)
+		})
+		return list
+	}
+	if cmd.run == nil {
+		for _, c := range cmd.sub {
+			list = append(list, c.name)
+		}
+		return list
+	}
+	if len(cmd.argFields) < 1 {
+		return nil
+	}
+	field := cmd.argFields[len(cmd.argFields)-1]
+	if positional < len(cmd.argFields) {
+		field = cmd.argFields[positional]
+	} else if !variadic(cmd) {
+		return nil
+	}
+	switch {
+	case strings.Contains(field, "Selector"):
+		return selectorValues(prefix, cache())
+	case strings.Contains(field, "Serial Number") && strings.Contains(field, "Device Type"):
+		cc := cache()
+		return append(append(list, cc.SerialNumbers...), cc.DeviceTypes...)
+	case strings.Contains(field, "Serial Number"):
+		return cache().SerialNumbers
+	case strings.Contains(field, "Device Type"):
+		list = cache().DeviceTypes
+		if strings.Contains(field, "all") {
+			list = append(list, "all")
+		}
+		return list
+	}
+	return nil
+}
+
+// selectorValues offers Serial Numbers and selector keys, or the values of the
+// key being typed. Spaces in revisions are written as the ? glob, as selector
+// terms are separated by whitespace.
+func selectorValues(prefix string, cc *completionCache) []string {
+	i := strings.IndexAny(prefix, "=~")
+	if i < 0 {
+		list := append([]string{}, cc.SerialNumbers...)
+		for _, key := range tipWifi.SelectorKeys {
+			list = append(list, key+"=")
+		}
+		return list
+	}
+	key := strings.TrimSuffix(prefix[:i], "!")
+	op := prefix[len(key) : i+1]
+	var values []string
+	switch strings.ToLower(key) {
+	case "serial":
+		values = cc.SerialNumbers
+	case "devicetype":
+		values = cc.DeviceTypes
+	case "revision", "firmware":
+		for _, rev := range cc.Revisions {
+			values = append(values, strings.Replace(rev, " ", "?", -1))
+		}
+	case "connected":
+		values = []string{"true", "false"}
+	}
+	var list []string
+	for _, v := range values {
+		list = append(list, key+op+v)
+	}
+	return list
+}
+
+// flagValues returns the values offered for a flag that takes one.
+func flagValues(name string) []string {
+	switch name {
+	case "info":
+		return tipWifi.DeviceInfo
+	case "output":
+		var list []string
+		for _, f := range tipWifi.OutputFormats {
+			if f == "template" {
+				f += "="
+			}
+			list = append(list, f)
+		}
+		return list
+	case "profile":
+		var list []string
+		cfg, err := loadConfig(*confFlag)
+		if err == nil {
+			for name := range cfg.Profiles {
+				list = append(list, name)
+			}
+		}
+		sort.Strings(list)
+		return list
+	}
+	return nil
+}
+
+func lookupFlag(cmd *command, name string) *flag.Flag {
+	if cmd == commands {
+		return flag.CommandLine.Lookup(name)
+	}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	return fs.Lookup(name)
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
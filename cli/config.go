@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var configCmd = &command{
+	name:    "config",
+	summary: "Retrieve, push and compare device configurations",
+	sub: []*command{
+		{
+			name:      "get",
+			summary:   "Print the configuration of a device, as a JSON document with -output json",
+			argFields: []string{"Serial Number"},
+			flags: func(fs *flag.FlagSet) {
+				fs.String("o", "", "Write the configuration as JSON to a file instead of stdout")
+			},
+			run: configGet,
+		},
+		{
+			name:      "push",
+			summary:   "Push a JSON configuration file to a device",
+			argFields: []string{"Serial Number", "Configuration File"},
+			run:       configPush,
+		},
+		{
+			name:      "diff",
+			summary:   "Compare a JSON configuration file with the configuration of a device",
+			argFields: []string{"Serial Number", "Configuration File"},
+			run:       configDiff,
+		},
+	},
+}
+
+func configGet(ctx *context) error {
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return err
+	}
+	cfg, err := ctx.uc.GetDeviceConfiguration(sn)
+	if err != nil {
+		return err
+	}
+	file := ctx.flagString("o")
+	if file == "" && *outputFlag != "json" {
+		r, err := tipWifi.ConfigurationReport(cfg)
+		if err != nil {
+			return err
+		}
+		return ctx.render(r)
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	if file != "" {
+		// configurations carry secrets such as RADIUS and Wi-Fi keys
+		return ioutil.WriteFile(file, out.Bytes(), 0600)
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
+
+func readConfigFile(file string) (json.RawMessage, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, usagef("%s :Not a valid JSON configuration", file)
+	}
+	return data, nil
+}
+
+func configPush(ctx *context) error {
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return err
+	}
+	cfg, err := readConfigFile(ctx.fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	err = ctx.uc.ConfigureDevice(sn, cfg)
+	if err != nil {
+		return err
+	}
+	log.Println(sn, ":Configuration Pushed")
+	return nil
+}
+
+func configDiff(ctx *context) error {
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return err
+	}
+	local, err := readConfigFile(ctx.fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	current, err := ctx.uc.GetDeviceConfiguration(sn)
+	if err != nil {
+		return err
+	}
+	list, err := tipWifi.CompareConfiguration(current, local)
+	if err != nil {
+		return err
+	}
+	return ctx.render(tipWifi.ConfigDifferenceReport(list))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var devicesCmd = &command{
+	name:    "devices",
+	summary: "List, inspect and operate on GW devices",
+	sub: []*command{
+		{
+			name:    "list",
+			summary: "List every device registered on the GW",
+			flags: func(fs *flag.FlagSet) {
+				fs.String("info", "", fmt.Sprintf("Info Type to report %v", tipWifi.DeviceInfo))
+				watchFlag(fs)
+			},
+			run: devicesList,
+		},
+		{
+			name:    "status",
+			summary: "Report whether each FMS device is UP or DOWN",
+			flags:   watchFlag,
+			run:     devicesStatus,
+		},
+		{
+			name:      "get",
+			summary:   "Show a single device",
+			argFields: []string{"Serial Number"},
+			flags: func(fs *flag.FlagSet) {
+				fs.String("info", "", fmt.Sprintf("Info Type to report %v", tipWifi.DeviceInfo))
+				watchFlag(fs)
+			},
+			run: devicesGet,
+		},
+		{
+			name:      "reboot",
+			summary:   "Reboot the selected devices",
+			argFields: []string{"Serial Number or Selector"},
+			run:       devicesReboot,
+		},
+		{
+			name:      "factory",
+			summary:   "Factory reset the selected devices",
+			argFields: []string{"Serial Number or Selector"},
+			flags: func(fs *flag.FlagSet) {
+				fs.Bool("keep-redirector", true, "Keep the Redirector the device uses to reach uCentral")
+			},
+			run: devicesFactory,
+		},
+		{
+			name:      "health",
+			summary:   "Score the health of the selected devices, or of the fleet, worst first",
+			argFields: []string{"<Serial Number or Selector>"},
+			flags: func(fs *flag.FlagSet) {
+				fs.Int("top", 20, "Devices to report, 0 for all")
+				fs.Duration("window", tipWifi.DefaultHealthWindow, "Period over which reboots are counted")
+				fs.Duration("config-lag", tipWifi.DefaultConfigLag, "Penalise a configuration change not downloaded for longer than this")
+			},
+			run: devicesHealth,
+		},
+		{
+			name:      "config-lag",
+			summary:   "Report devices that have not downloaded their latest configuration and offer to re-push it",
+			argFields: []string{"<Serial Number or Selector>"},
+			flags: func(fs *flag.FlagSet) {
+				fs.Duration("threshold", tipWifi.DefaultConfigLag, "Report a configuration change not downloaded for longer than this")
+				fs.Bool("repush", false, "Re-push the configuration to the connected lagging devices, asking as other destructive commands do")
+			},
+			run: devicesConfigLag,
+		},
+		{
+			name:      "annotate",
+			summary:   "Add notes to the selected devices",
+			argFields: []string{"Serial Number or Selector", "Note", "<more notes>"},
+			run:       devicesAnnotate,
+		},
+	},
+}
+
+func infoFlag(ctx *context) (string, error) {
+	info := strings.ToLower(ctx.flagString("info"))
+	if info != "" && ctx.flagDuration("watch") > 0 {
+		return "", usagef("-info cannot be combined with -watch")
+	}
+	if info != "" && !existsInList(info, tipWifi.DeviceInfo) {
+		return "", usagef("%s :Invalid Info Type, must be one of %v", info, tipWifi.DeviceInfo)
+	}
+	return info, nil
+}
+
+func devicesList(ctx *context) error {
+	info, err := infoFlag(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.flagDuration("watch") > 0 {
+		return ctx.watch()
+	}
+	devs, err := ctx.uc.ListDevices()
+	if err != nil {
+		return err
+	}
+	return ctx.render(devs.Report(info))
+}
+
+func devicesStatus(ctx *context) error {
+	if ctx.flagDuration("watch") > 0 {
+		return ctx.watch()
+	}
+	fwds, err := ctx.uc.GetAllFirmwareDevices()
+	if err != nil {
+		return err
+	}
+	return ctx.render(fwds.StatusReport())
+}
+
+func devicesGet(ctx *context) error {
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return err
+	}
+	info, err := infoFlag(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.flagDuration("watch") > 0 {
+		return ctx.watch(sn)
+	}
+	dev, err := ctx.uc.GetDevice(sn)
+	if err != nil {
+		return err
+	}
+	return ctx.render(dev.Report(info))
+}
+
+func devicesReboot(ctx *context) error {
+	sns, err := ctx.devices(0)
+	if err != nil || sns == nil {
+		return err
+	}
+	err = ctx.confirm("Reboot", sns, false)
+	if err != nil {
+		return err
+	}
+	return ctx.bulk("reboot", sns, func(sn string) (string, error) {
+		return "Rebooting", ctx.uc.RebootDevice(sn)
+	})
+}
+
+func devicesFactory(ctx *context) error {
+	sns, err := ctx.devices(0)
+	if err != nil || sns == nil {
+		return err
+	}
+	err = ctx.confirm("Factory reset", sns, true)
+	if err != nil {
+		return err
+	}
+	keep := ctx.flagBool("keep-redirector")
+	return ctx.bulk("factory", sns, func(sn string) (string, error) {
+		return "Factory Reset", ctx.uc.FactoryResetDevice(sn, keep)
+	})
+}
+
+func devicesAnnotate(ctx *context) error {
+	sns, err := ctx.devices(0)
+	if err != nil || sns == nil {
+		return err
+	}
+	notes := ctx.fs.Args()[1:]
+	return ctx.bulk("annotate", sns, func(sn string) (string, error) {
+		return fmt.Sprintf("Added %d note(s)", len(notes)), ctx.uc.AddNotesToDevice(sn, notes)
+	})
+}
+
+func devicesHealth(ctx *context) error {
+	var sns []string
+	if ctx.fs.NArg() > 0 {
+		var err error
+		sns, err = ctx.devices(0)
+		if err != nil || sns == nil {
+			return err
+		}
+	}
+	opts := &tipWifi.HealthOptions{
+		Window:    ctx.flagDuration("window"),
+		ConfigLag: ctx.flagDuration("config-lag"),
+		Bulk: &tipWifi.BulkOptions{
+			Workers:   *workFlag,
+			Timeout:   *timeFlag,
+			Interrupt: true,
+		},
+	}
+	if len(sns) == 1 {
+		hs, err := ctx.uc.ScoreDevice(sns[0], opts)
+		if err != nil {
+			return err
+		}
+		return ctx.render(hs.Report())
+	}
+	opts.Bulk.Progress = os.Stderr
+	fh, err := ctx.uc.ScoreFleet(opts, sns...)
+	if err != nil {
+		return err
+	}
+	if fh.Failed != nil {
+		for _, r := range fh.Failed.Entry {
+			if r.Status != tipWifi.BulkOK {
+				log.Printf("%s: Not scored, %s %s\n", r.SerialNumber, r.Status, r.Error)
+			}
+		}
+	}
+	return ctx.render(fh.Report(ctx.flagInt("top")))
+}
+
+// devicesConfigLag reports the lagging devices and, on a terminal or with -repush,
+// re-pushes the configuration of those that are connected. Disconnected devices
+// download their configuration when they reconnect, so they are left alone.
+func devicesConfigLag(ctx *context) error {
+	var sns []string
+	if ctx.fs.NArg() > 0 {
+		var err error
+		sns, err = ctx.devices(0)
+		if err != nil || sns == nil {
+			return err
+		}
+	}
+	r, err := ctx.uc.CheckConfigLag(ctx.flagDuration("threshold"), sns...)
+	if err != nil {
+		return err
+	}
+	err = ctx.render(r.Report())
+	if err != nil {
+		return err
+	}
+	connected := r.SerialNumbers()
+	if len(connected) < 1 {
+		return nil
+	}
+	if !ctx.flagBool("repush") && !isTerminal(os.Stdin) {
+		log.Printf("Supply -repush to re-push the configuration of %d connected device(s)\n", len(connected))
+		return nil
+	}
+	ctx.selection = nil
+	err = ctx.confirm("Re-push configuration to", connected, !ctx.flagBool("repush"))
+	if err != nil {
+		if err == errCancelled {
+			return nil
+		}
+		return err
+	}
+	return ctx.bulk("repush", connected, func(sn string) (string, error) {
+		return "Configuration pushed", ctx.uc.RepushConfiguration(sn)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var exporterCmd = &command{
+	name:    "exporter",
+	summary: "Serve fleet metrics to Prometheus on /metrics",
+	flags: func(fs *flag.FlagSet) {
+		fs.String("listen", ":9110", "Address to serve the metrics on")
+		fs.Duration("interval", tipWifi.DefaultExporterInterval, "Refresh Interval of the metrics from the GW and FMS")
+	},
+	run: exporterRun,
+}
+
+// exporterRun refreshes the metrics in the background and serves them until
+// interrupted or terminated. -workers limits the devices queried at once.
+func exporterRun(ctx *context) error {
+	ex := tipWifi.NewExporter(ctx.uc, ctx.flagDuration("interval"))
+	ex.Workers = *workFlag
+	ex.OnError = func(err error) {
+		log.Println(err)
+	}
+	ex.Reauthenticate = func() error {
+		_, p, err := connection()
+		if err != nil {
+			return err
+		}
+		return login(ctx.uc, p)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", ex)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><a href="/metrics">Metrics</a></body></html>`)
+	})
+	srv := &http.Server{
+		Addr:    ctx.flagString("listen"),
+		Handler: mux,
+	}
+	go ex.Run()
+	defer ex.Stop()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		log.Println("Stopping exporter")
+		srv.Close()
+	}()
+	log.Printf("Serving metrics on %s/metrics, refreshing every %s\n", srv.Addr, ctx.flagDuration("interval"))
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var firmwareCmd = &command{
+	name:    "firmware",
+	summary: "Query the FMS and upgrade device firmware",
+	sub: []*command{
+		{
+			name:      "list",
+			summary:   "List the firmware images of a Device Type",
+			argFields: []string{"Device Type"},
+			run:       firmwareList,
+		},
+		{
+			name:      "latest",
+			summary:   "Show the latest firmware image of a Device Type",
+			argFields: []string{"Device Type"},
+			run:       firmwareLatest,
+		},
+		{
+			name:      "upgrade",
+			summary:   "Upgrade the selected devices to the latest or a supplied image",
+			argFields: []string{"Serial Number or Selector"},
+			flags: func(fs *flag.FlagSet) {
+				fs.String("uri", "", "Image URI to upgrade to instead of the latest")
+				fs.Bool("verify", false, "Wait for the device to reconnect on the new revision")
+				fs.Bool("rollback", true, "With -verify, re-flash the previous image if verification fails")
+				fs.Duration("verify-timeout", 10*time.Minute, "With -verify, how long to wait for the device to reconnect")
+				fs.Int("min-sanity", 0, "With -verify, minimum health check sanity after the upgrade")
+				alertFlags(fs)
+			},
+			run: firmwareUpgrade,
+		},
+		{
+			name:      "history",
+			summary:   "Show the release history of a Device Type, or the changelog of a device",
+			argFields: []string{"Device Type or Serial Number"},
+			run:       firmwareHistory,
+		},
+		{
+			name:    "compliance",
+			summary: "Report which devices run outdated firmware",
+			flags: func(fs *flag.FlagSet) {
+				fs.Bool("summary", false, "Show the counts of each Device Type instead of every device")
+			},
+			run: firmwareCompliance,
+		},
+		{
+			name:    "types",
+			summary: "List the Device Types known to the FMS",
+			flags: func(fs *flag.FlagSet) {
+				fs.Bool("refresh", false, "Ignore the cached catalog")
+			},
+			run: firmwareTypes,
+		},
+		{
+			name:      "policy",
+			summary:   "Check whether upgrade policy allows upgrading a device to the latest image",
+			argFields: []string{"Serial Number"},
+			run:       firmwarePolicy,
+		},
+		rolloutCmd,
+		mirrorCmd,
+	},
+}
+
+var rolloutCmd = &command{
+	name:    "rollout",
+	summary: "Run staged firmware rollout campaigns",
+	sub: []*command{
+		{
+			name:      "start",
+			summary:   "Start or continue the campaign described by a Campaign File",
+			argFields: []string{"Campaign File"},
+			flags:     alertFlags,
+			run:       rolloutStart,
+		},
+		{
+			name:      "pause",
+			summary:   "Pause a running campaign before its next wave",
+			argFields: []string{"Campaign File"},
+			offline:   true,
+			run:       rolloutPause,
+		},
+		{
+			name:      "resume",
+			summary:   "Clear a pause and continue the campaign",
+			argFields: []string{"Campaign File"},
+			flags:     alertFlags,
+			run:       rolloutResume,
+		},
+		{
+			name:      "status",
+			summary:   "Show the progress of a campaign",
+			argFields: []string{"Campaign File"},
+			offline:   true,
+			run:       rolloutStatus,
+		},
+	},
+}
+
+var mirrorCmd = &command{
+	name:    "mirror",
+	summary: "Maintain and serve a local firmware mirror (requires -mirror)",
+	sub: []*command{
+		{
+			name:      "sync",
+			summary:   "Download and verify the images of a Device Type",
+			argFields: []string{"Device Type or all"},
+			run:       mirrorSync,
+		},
+		{
+			name:    "serve",
+			summary: "Serve the mirror directory over HTTP",
+			flags: func(fs *flag.FlagSet) {
+				fs.String("addr", ":8080", "Listen Address")
+			},
+			offline: true,
+			run:     mirrorServe,
+		},
+	},
+}
+
+// deviceType returns the positional argument at index i validated against the DeviceCatalog.
+func (ctx *context) deviceType(i int) (string, error) {
+	devType := strings.ToLower(ctx.fs.Arg(i))
+	cat, err := ctx.uc.GetDeviceCatalog(tipWifi.DefaultCatalogPath(), tipWifi.DefaultCatalogTTL)
+	if err != nil {
+		return "", err
+	}
+	if !cat.Contains(devType) {
+		for _, v := range cat.DeviceTypes() {
+			log.Printf("\t%s\n", v)
+		}
+		return "", usagef("%s :Invalid Device Type Supplied", devType)
+	}
+	return devType, nil
+}
+
+func firmwareList(ctx *context) error {
+	devType, err := ctx.deviceType(0)
+	if err != nil {
+		return err
+	}
+	fws, err := ctx.uc.GetFirmwareListByDevice(devType)
+	if err != nil {
+		return err
+	}
+	return ctx.render(fws.Report())
+}
+
+func firmwareLatest(ctx *context) error {
+	devType, err := ctx.deviceType(0)
+	if err != nil {
+		return err
+	}
+	fw, err := ctx.uc.GetLatestFirmwareByDevice(devType)
+	if err != nil {
+		return err
+	}
+	return ctx.render(fw.Report())
+}
+
+func firmwareUpgrade(ctx *context) error {
+	sns, err := ctx.devices(0)
+	if err != nil || sns == nil {
+		return err
+	}
+	if ctx.flagBool("verify") {
+		// leave each device time to verify, roll back and verify again
+		need := ctx.verifyOptions().Duration() + 2*time.Minute
+		if *timeFlag < need {
+			if globalFlagSet("timeout") {
+				return usagef("-timeout %s is shorter than the %s -verify may take, raise it or lower -verify-timeout", *timeFlag, need)
+			}
+			*timeFlag = need
+		}
+	}
+	err = ctx.confirm("Upgrade", sns, false)
+	if err != nil {
+		return err
+	}
+	alerts, err := alertSink(ctx)
+	if err != nil {
+		return err
+	}
+	return ctx.bulk("upgrade", sns, func(sn string) (string, error) {
+		return upgradeDevice(ctx, sn, alerts)
+	})
+}
+
+// upgradeDevice upgrades one device, alerting on a verified upgrade that failed.
+func upgradeDevice(ctx *context, sn string, alerts tipWifi.Sink) (string, error) {
+	fwd, err := ctx.uc.GetFirmwareDevice(sn)
+	if err != nil {
+		return "", err
+	}
+	uri := ctx.flagString("uri")
+	if !ctx.flagBool("verify") || ctx.uc.DryRun {
+		if uri != "" {
+			return "Upgrading to " + uri, ctx.uc.UpgradeDeviceToURI(fwd, uri)
+		}
+		return "Upgrading to latest", ctx.uc.UpgradeDeviceToLatest(fwd)
+	}
+	if uri == "" {
+		fw, err := ctx.uc.GetLatestFirmwareByDevice(fwd.DeviceType)
+		if err != nil {
+			return "", err
+		}
+		uri = fw.URI
+	}
+	ur, err := ctx.uc.UpgradeAndVerify(sn, uri, ctx.verifyOptions())
+	if ur == nil {
+		return "", err
+	}
+	if _, refused := err.(*tipWifi.PolicyError); err != nil && !refused && alerts != nil {
+		alerts.Send(tipWifi.UpgradeFailedEvent(sn, fwd.DeviceType, ur.PreviousRevision, ur.ExpectRevision))
+	}
+	return ur.GenerateDescription(), err
+}
+
+func (ctx *context) verifyOptions() *tipWifi.VerifyOptions {
+	return &tipWifi.VerifyOptions{
+		Timeout:   ctx.flagDuration("verify-timeout"),
+		Rollback:  ctx.flagBool("rollback"),
+		MinSanity: ctx.flagInt("min-sanity"),
+	}
+}
+
+func firmwareHistory(ctx *context) error {
+	target := strings.ToLower(ctx.fs.Arg(0))
+	cat, err := ctx.uc.GetDeviceCatalog(tipWifi.DefaultCatalogPath(), tipWifi.DefaultCatalogTTL)
+	if err == nil && cat.Contains(target) {
+		h, err := ctx.uc.GetFirmwareHistory(target)
+		if err != nil {
+			return err
+		}
+		return ctx.render(h.Report())
+	}
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return usagef("%s :Invalid Device Type or SN Supplied", target)
+	}
+	cl, err := ctx.uc.GetFirmwareChangelog(sn)
+	if err != nil {
+		return err
+	}
+	return ctx.render(cl.Report())
+}
+
+func firmwareCompliance(ctx *context) error {
+	cr, err := ctx.uc.GenerateComplianceReport()
+	if err != nil {
+		return err
+	}
+	if ctx.flagBool("summary") {
+		return ctx.render(cr.GroupReport())
+	}
+	return ctx.render(cr.Report())
+}
+
+func firmwareTypes(ctx *context) error {
+	ttl := tipWifi.DefaultCatalogTTL
+	if ctx.flagBool("refresh") {
+		ttl = 0
+	}
+	cat, err := ctx.uc.GetDeviceCatalog(tipWifi.DefaultCatalogPath(), ttl)
+	if err != nil {
+		return err
+	}
+	return ctx.render(cat.Report())
+}
+
+func firmwarePolicy(ctx *context) error {
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return err
+	}
+	fwd, err := ctx.uc.GetFirmwareDevice(sn)
+	if err != nil {
+		return err
+	}
+	fw, err := ctx.uc.GetLatestFirmwareByDevice(fwd.DeviceType)
+	if err != nil {
+		return err
+	}
+	err = ctx.uc.CheckUpgradePolicy(sn, fw)
+	if err != nil {
+		return err
+	}
+	log.Println(sn, ":Upgrade to", fw.Revision, "allowed by policy")
+	return nil
+}
+
+func rolloutStart(ctx *context) error {
+	c, err := tipWifi.LoadCampaign(ctx.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c.Alerts, err = alertSink(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.Run(ctx.uc)
+	log.Println(c.Status, c.Summary())
+	return err
+}
+
+func rolloutPause(ctx *context) error {
+	c, err := tipWifi.LoadCampaign(ctx.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.Pause()
+}
+
+func rolloutResume(ctx *context) error {
+	c, err := tipWifi.LoadCampaign(ctx.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = c.Resume()
+	if err != nil {
+		return err
+	}
+	c.Alerts, err = alertSink(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.Run(ctx.uc)
+	log.Println(c.Status, c.Summary())
+	return err
+}
+
+func rolloutStatus(ctx *context) error {
+	c, err := tipWifi.LoadCampaign(ctx.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	log.Println(c.Status, c.Summary())
+	return ctx.render(c.Report())
+}
+
+func mirrorSync(ctx *context) error {
+	if ctx.uc.Mirror == nil {
+		return usagef("Must supply -mirror directory")
+	}
+	devTypes := []string{strings.ToLower(ctx.fs.Arg(0))}
+	if devTypes[0] == "all" {
+		cat, err := ctx.uc.GetDeviceCatalog(tipWifi.DefaultCatalogPath(), tipWifi.DefaultCatalogTTL)
+		if err != nil {
+			return err
+		}
+		devTypes = cat.DeviceTypes()
+	}
+	var failed error
+	r := tipWifi.NewReport("Fetched", "deviceType", "image")
+	for _, devType := range devTypes {
+		fetched, err := ctx.uc.Mirror.Sync(ctx.uc, devType)
+		if err != nil {
+			log.Println(devType, err)
+			failed = err
+		}
+		for _, img := range fetched {
+			r.Add(devType, img)
+		}
+	}
+	err := ctx.render(r)
+	if failed != nil {
+		return failed
+	}
+	return err
+}
+
+func mirrorServe(ctx *context) error {
+	if *mirrorFlag == "" {
+		return usagef("Must supply -mirror directory")
+	}
+	m := &tipWifi.Mirror{
+		Dir:  *mirrorFlag,
+		Addr: ctx.flagString("addr"),
+	}
+	log.Printf("Serving %s on %s\n", m.Dir, m.Addr)
+	return m.Serve()
+}
@@ -1,277 +1,381 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/lindsaybb/tipWifi"
 )
 
+// Exit codes returned by the CLI.
+const (
+	exitOK    = 0
+	exitError = 1 // the command ran but the operation failed
+	exitUsage = 2 // unknown command, missing or invalid arguments
+	exitAuth  = 3 // could not log in to uCentral or discover its endpoints
+)
+
 var (
-	userFlag   = flag.String("un", "tip@ucentral.com", "uCentral Username")
-	passFlag   = flag.String("pw", "openwifi", "uCentral Password")
-	secUrlFlag = flag.String("sec", "lindsay.arilia.com:16001", "uCentral Security Endpoint")
+	userFlag   = flag.String("un", "", "uCentral Username, overrides the profile")
+	passFlag   = flag.String("pw", "", "uCentral Password, prefer the profile's credential source or $TIPWIFI_PASSWORD")
+	secUrlFlag = flag.String("sec", "", "uCentral Security Endpoint, overrides the profile")
+	profFlag   = flag.String("profile", "", "Controller profile from the config file")
+	confFlag   = flag.String("config", defaultConfigPath(), "CLI Configuration File")
+	sessFlag   = flag.Bool("session", true, "Reuse and cache the login session of the profile")
 	helpFlag   = flag.Bool("h", false, "Show this help")
+	mirrorFlag = flag.String("mirror", "", "Local Firmware Mirror Directory")
+	mirURLFlag = flag.String("mirrorurl", "", "URL at which devices reach the Firmware Mirror")
+	policyFlag = flag.String("policy", "", "Firmware Upgrade Policy File")
+	prevFlag   = flag.Bool("preview", false, "List the devices a selector matches without running the command")
+	dryFlag    = flag.Bool("dry-run", false, "Print the requests that would change devices instead of sending them")
+	yesFlag    = flag.Bool("yes", false, "Do not ask for confirmation before destructive commands")
+	maxFlag    = flag.Int("max-devices", 25, "Most devices a destructive command may touch, raise to override")
+	workFlag   = flag.Int("workers", 8, "Devices a bulk command operates on at once")
+	timeFlag   = flag.Duration("timeout", 15*time.Minute, "Per-device timeout of bulk commands")
+	failFlag   = flag.String("failed", "", "Write the Serial Numbers of devices a bulk command failed on to a file")
+	outputFlag = flag.String("output", "table", "Report Output Format: table, json, yaml, csv or template=<Go template>")
 )
 
-var validArgs = []string{
-	"listdevices",
-	"getdevice",
-	"getfirmware",
-	"upgradefirmware",
-	"reboot",
-	"annotate",
-	"factory",
+// The command object is a node of the CLI's subcommand tree. Leaf commands have a
+// run function, while group commands only hold their subcommands. The argFields
+// name the positional arguments in order, with optional ones enclosed in <>.
+type command struct {
+	name      string
+	summary   string
+	argFields []string
+	flags     func(fs *flag.FlagSet)
+	run       func(ctx *context) error
+	offline   bool // the command does not need a uCentral session
+	sub       []*command
 }
 
-var argFields = map[string][]string{
-	validArgs[0]: []string{"<Info Type>"},
-	validArgs[1]: []string{"Serial Number"},
-	validArgs[2]: []string{"Device Type", "<all>"},
-	validArgs[3]: []string{"Serial Number", "<url>"},
-	validArgs[4]: []string{"Serial Number"},
-	validArgs[5]: []string{"Serial Number", "[comma-separated notes in quotes]"},
-	validArgs[6]: []string{"Serial Number", "<supply 'false' if don't want to keep Redirector>"},
+// The context object carries everything a command's run function needs.
+type context struct {
+	cmd       *command
+	path      string
+	fs        *flag.FlagSet
+	uc        *tipWifi.UCentral
+	selection *tipWifi.Selection // devices matched by a selector argument
 }
 
-/*
-func usage() {
-	fmt.Println(`Program Description:
-	Call this program will appropriate arguments`)
-	for _, v := range validArgs {
-		fmt.Printf("%s: ", v)
-		for _, o := range argFields[v] {
-			fmt.Printf("%s ", o)
-		}
-		fmt.Println()
-	}
+// The usageError is returned by commands when they are invoked incorrectly.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, a ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, a...)}
+}
+
+var commands = &command{
+	name:    "tipwifi",
+	summary: "Manage uCentral devices and firmware",
+	sub: []*command{
+		devicesCmd,
+		firmwareCmd,
+		configCmd,
+		loginCmd,
+		logoutCmd,
+		whoamiCmd,
+		shellCmd,
+		runCmd,
+		monitorCmd,
+		exporterCmd,
+		notificationsCmd,
+		storeCmd,
+		completionCmd,
+	},
 }
-*/
-func init() {
+
+func main() {
+	flag.Usage = func() {
+		printHelp(commands.name, commands)
+	}
 	flag.Parse()
+	if !validOutput(*outputFlag) {
+		log.Printf("%s :Invalid Output Format, must be one of %v\n", *outputFlag, tipWifi.OutputFormats)
+		os.Exit(exitUsage)
+	}
 	if *helpFlag || flag.NArg() < 1 {
-		//usage()
-		flag.PrintDefaults()
-		os.Exit(0)
+		flag.Usage()
+		os.Exit(exitOK)
+	}
+	if flag.Arg(0) == "__complete" {
+		completeMain(flag.Args()[1:])
+		os.Exit(exitOK)
 	}
+	if flag.Arg(0) == "help" {
+		cmd, path, _ := resolve(flag.Args()[1:])
+		printHelp(path, cmd)
+		os.Exit(exitOK)
+	}
+	os.Exit(execute(flag.Args()))
 }
 
-func main() {
-	uc := &tipWifi.UCentral{
-		SEC: *secUrlFlag,
-		Auth: &tipWifi.Auth{
-			UserID:   *userFlag,
-			Password: *passFlag,
-		},
-	}
-	err := uc.Login()
-	//uc.OAuth2, err := uClig.LoginUCentral(un, pw, secUrl)
+// execute resolves and runs a single command line, returning the exit code.
+func execute(args []string) int {
+	return executeWith(nil, args)
+}
+
+// executeWith runs a command line on an existing session, or logs in first when
+// uc is nil and the command needs one.
+func executeWith(uc *tipWifi.UCentral, args []string) int {
+	cmd, path, rest := resolve(args)
+	if cmd.run == nil {
+		if len(rest) > 0 {
+			log.Printf("%s: unknown command %q\n", path, rest[0])
+		}
+		printHelp(path, cmd)
+		return exitUsage
+	}
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.Usage = func() {
+		printHelp(path, cmd)
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	err := fs.Parse(rest)
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	if min := requiredArgs(cmd); fs.NArg() < min {
+		log.Printf("%s: Must supply %s\n", path, strings.Join(cmd.argFields[fs.NArg():min], ", "))
+		printHelp(path, cmd)
+		return exitUsage
+	}
+	if max := len(cmd.argFields); fs.NArg() > max && !variadic(cmd) {
+		// flags after positional arguments are not parsed, so a stray value
+		// would otherwise be silently ignored
+		log.Printf("%s: Unexpected argument %q, flags must come before arguments\n", path, fs.Arg(max))
+		printHelp(path, cmd)
+		return exitUsage
+	}
+	ctx := &context{cmd: cmd, path: path, fs: fs, uc: uc}
+	if !cmd.offline && ctx.uc == nil {
+		ctx.uc, err = session()
+		if err != nil {
+			log.Println(err)
+			return exitAuth
+		}
+		if !*sessFlag {
+			defer logout(ctx.uc)
+		}
+	}
+	err = cmd.run(ctx)
 	if err != nil {
-		log.Fatalln(err)
+		log.Printf("%s: %s\n", path, err)
+		var ue *usageError
+		if errors.As(err, &ue) {
+			return exitUsage
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+// resolve walks the subcommand tree along args, returning the deepest command
+// found, its full path and the remaining arguments.
+func resolve(args []string) (*command, string, []string) {
+	cmd := commands
+	path := cmd.name
+	for len(args) > 0 {
+		next := findCommand(cmd, args[0])
+		if next == nil {
+			break
+		}
+		cmd = next
+		path += " " + next.name
+		args = args[1:]
+	}
+	return cmd, path, args
+}
+
+func findCommand(cmd *command, name string) *command {
+	for _, c := range cmd.sub {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// requiredArgs returns the number of leading argFields that are not optional.
+func requiredArgs(cmd *command) (n int) {
+	for _, a := range cmd.argFields {
+		if strings.HasPrefix(a, "<") {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// variadic reports whether the last of the argFields accepts more than one value.
+func variadic(cmd *command) bool {
+	return len(cmd.argFields) > 0 && strings.HasPrefix(cmd.argFields[len(cmd.argFields)-1], "<more")
+}
+
+// printHelp generates the help text of a command from its argFields, flags and subcommands.
+func printHelp(path string, cmd *command) {
+	w := os.Stderr
+	fmt.Fprintf(w, "%s - %s\n\nUsage:\n", path, cmd.summary)
+	if cmd.run != nil {
+		fmt.Fprintf(w, "  %s [flags]", path)
+		for _, a := range cmd.argFields {
+			fmt.Fprintf(w, " %q", a)
+		}
+		fmt.Fprintln(w)
+		if cmd.flags != nil {
+			fs := flag.NewFlagSet(path, flag.ContinueOnError)
+			cmd.flags(fs)
+			fmt.Fprintln(w, "\nFlags:")
+			fs.SetOutput(w)
+			fs.PrintDefaults()
+		}
 	} else {
-		log.Println("Logged In to uCentral")
+		fmt.Fprintf(w, "  %s <command>\n\nCommands:\n", path)
+		for _, c := range cmd.sub {
+			fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+		}
 	}
-	defer logout(uc)
-	//uc.OAuth2.DisplayToken()
-	err = uc.PopulateEndpoints()
+	for _, a := range cmd.argFields {
+		if strings.Contains(a, "Selector") {
+			fmt.Fprint(w, selectorHelp)
+			break
+		}
+	}
+	if cmd == runCmd {
+		fmt.Fprint(w, scriptHelp)
+	}
+	if cmd == commands {
+		fmt.Fprintln(w, "\nGlobal Flags:")
+		flag.CommandLine.SetOutput(w)
+		flag.PrintDefaults()
+	}
+}
+
+// connection resolves the SEC endpoint and username from the selected profile,
+// with the -sec and -un flags taking precedence over it. The returned name
+// identifies the connection for the session cache.
+func connection() (*tipWifi.UCentral, *profile, error) {
+	cfg, err := loadConfig(*confFlag)
 	if err != nil {
-		log.Println(err)
+		return nil, nil, err
+	}
+	p, err := cfg.selectProfile(*profFlag)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p == nil {
+		p = &profile{Name: "default"}
+	}
+	if p.CABundle != "" {
+		err = tipWifi.SetCABundle(p.CABundle)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	uc := &tipWifi.UCentral{
+		SEC:  p.SEC,
+		Auth: &tipWifi.Auth{UserID: p.Username},
+	}
+	if *secUrlFlag != "" {
+		uc.SEC = *secUrlFlag
 	}
+	if *userFlag != "" {
+		uc.Auth.UserID = *userFlag
+	}
+	return uc, p, nil
+}
 
-	// declaring them here to persist between loops... necessary?
-	var devs *tipWifi.Devices
-	var dev *tipWifi.Device
+// credentials fills in the password from the -pw flag, $TIPWIFI_PASSWORD or the
+// profile's credential source, in that order.
+func credentials(uc *tipWifi.UCentral, p *profile) (err error) {
+	uc.Auth.Password = *passFlag
+	if uc.Auth.Password == "" {
+		uc.Auth.Password = os.Getenv("TIPWIFI_PASSWORD")
+	}
+	if uc.Auth.Password == "" {
+		uc.Auth.Password, err = p.password()
+	}
+	return err
+}
+
+// sessionPath returns the session cache file of a profile.
+func sessionPath(p *profile) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tipwifi", "sessions", p.Name+".json")
+}
 
-	var skip bool
-	var skip2 bool
-	for n := range flag.Args() {
-		// Basic flow control allows for multi-command chains
-		// and for variables to be supplied with commands.
-		if skip2 {
-			skip = true
-			skip2 = false
-			continue
+// session returns an authenticated UCentral with its GW and FMS endpoints. With
+// -session, a cached login of the profile is reused while its token is valid,
+// and a new login is cached for the following invocations.
+func session() (*tipWifi.UCentral, error) {
+	uc, p, err := connection()
+	if err != nil {
+		return nil, err
+	}
+	if !*sessFlag || uc.RestoreSession(sessionPath(p)) != nil {
+		err = login(uc, p)
+		if err != nil {
+			return nil, err
 		}
-		if skip {
-			skip = false
-			continue
+	}
+	uc.DryRun = *dryFlag
+	if *policyFlag != "" {
+		uc.Policy, err = tipWifi.LoadPolicyEngine(*policyFlag)
+		if err != nil {
+			return nil, err
 		}
-		arg := parseArg(flag.Args()[n])
-		switch arg {
-		case 0:
-			// "listdevices",
-			var info string
-			if flag.NArg() >= (n + 2) {
-				next := strings.ToLower(flag.Args()[n+1])
-				if existsInList(next, tipWifi.DeviceInfo) {
-					info = next
-					skip = true
-				}
-			}
-			if devs == nil {
-				devs, err = uc.ListDevices()
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-			}
-			devs.GenerateDeviceReport(info)
-
-		case 1:
-			// "getdevice",
-			if flag.NArg() < (n + 1) {
-				log.Fatalln(validArgs[1], ":Must supply Device SN")
-			}
-			skip = true
-			sn := strings.ToLower(flag.Args()[n+1])
-			if len(sn) != 12 {
-				log.Fatalln(sn, ":Incorrect Device SN Length")
-			}
-			var info string
-			if flag.NArg() >= (n + 3) {
-				next := strings.ToLower(flag.Args()[n+2])
-				if existsInList(next, tipWifi.DeviceInfo) {
-					info = next
-					skip2 = true
-				}
-			}
-			if dev == nil || dev.SerialNumber != sn {
-				dev, err = uc.GetDevice(sn)
-				if err != nil {
-					log.Println(err)
-				}
-			}
-			tipWifi.DisplayList(dev.SerialNumber, dev.ListInfo(info))
-
-		case 2:
-			// "getfirmware",
-			if flag.NArg() < (n + 1) {
-				log.Fatalln(validArgs[2], ":Must supply Device Type")
-			}
-			skip = true
-			devType := strings.ToLower(flag.Args()[n+1])
-			if !existsInList(devType, tipWifi.DeviceTypes) {
-				log.Println("Retrieving Valid Device Types...")
-				validDevices, err := uc.ListFirmwareDeviceTypes()
-				if err != nil {
-					log.Fatalln(err)
-				}
-				for _, v := range validDevices {
-					log.Printf("\t%s\n", v)
-				}
-				log.Fatalln(devType, ":Invalid Device Type Supplied")
-			}
-			if flag.NArg() >= (n + 3) {
-				latest := strings.ToLower(flag.Args()[n+2])
-				if latest == "latest" {
-					skip2 = true
-					fw, err := uc.GetLatestFirmwareByDevice(devType)
-					if err != nil {
-						log.Fatalln(err)
-					}
-					tipWifi.DisplayList(devType, []string{fw.GenerateDescription()})
-					continue
-				}
-			}
-			fws, err := uc.GetFirmwareListByDevice(devType)
-			if err != nil {
-				log.Fatalln(err)
-			}
-			tipWifi.DisplayList(devType, fws.GenerateList())
-
-		case 3:
-			// "upgradefirmware",
-			if flag.NArg() < (n + 1) {
-				log.Fatalln(validArgs[3], ":Must supply Device SN")
-			}
-			skip = true
-			sn := strings.ToLower(flag.Args()[n+1])
-			if len(sn) != 12 {
-				log.Fatalln(sn, ":Incorrect Device SN Length")
-			}
-			fwd, err := uc.GetFirmwareDevice(sn)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			if flag.NArg() >= (n + 3) {
-				if strings.HasPrefix(flag.Args()[n+2], "http") {
-					skip2 = true
-					// optional arg of preferred URI supplied for upgrade
-					err = uc.UpgradeDeviceFirmware(fwd.SerialNumber, flag.Args()[n+2])
-					if err != nil {
-						log.Println(err)
-					}
-					// if upgrade is unsuccessful, could roll back to latest
-					// but rather exit and have the user call it again
-					continue
-				}
-			}
-			err = uc.UpgradeDeviceToLatest(fwd)
-			if err != nil {
-				log.Println(err)
-			}
-		case 4:
-			// "reboot"
-			if flag.NArg() < (n + 1) {
-				log.Fatalln(validArgs[4], ":Must supply Device SN")
-			}
-			skip = true
-			sn := strings.ToLower(flag.Args()[n+1])
-			if len(sn) != 12 {
-				log.Fatalln(sn, ":Incorrect Device SN Length")
-			}
-			err = uc.RebootDevice(sn)
-			if err != nil {
-				log.Println(err)
-			}
-		case 5:
-			// "annotate"
-			if flag.NArg() < (n + 1) {
-				log.Fatalln(validArgs[5], ":Must supply Device SN")
-			}
-			skip = true
-			sn := strings.ToLower(flag.Args()[n+1])
-			if len(sn) != 12 {
-				log.Fatalln(sn, ":Incorrect Device SN Length")
-			}
-			if flag.NArg() < (n + 3) {
-				log.Fatalln(validArgs[5], ":Missing the Notes!")
-			}
-			skip2 = true
-			notes := strings.Split(flag.Args()[n+2], ",")
-			err = uc.AddNotesToDevice(sn, notes)
-			if err != nil {
-				log.Println(err)
-			}
-		case 6:
-			// "factory"
-			if flag.NArg() < (n + 1) {
-				log.Fatalln(validArgs[5], ":Must supply Device SN")
-			}
-			skip = true
-			sn := strings.ToLower(flag.Args()[n+1])
-			if len(sn) != 12 {
-				log.Fatalln(sn, ":Incorrect Device SN Length")
-			}
-			keep := true
-			if flag.NArg() >= (n + 3) {
-				if strings.ToLower(flag.Args()[n+2]) == "false" {
-					keep = false
-				}
-			}
-			err = uc.FactoryResetDevice(sn, keep)
-			if err != nil {
-				log.Println(err)
-			}
-		default:
-			log.Printf("Unknown arg: %s\n", arg)
+	}
+	if *mirrorFlag != "" {
+		uc.Mirror = &tipWifi.Mirror{
+			Dir:     *mirrorFlag,
+			BaseURL: *mirURLFlag,
 		}
+	}
+	return uc, nil
+}
 
+// login authenticates with the SEC, discovers the endpoints and, with -session,
+// caches the result for the profile.
+func login(uc *tipWifi.UCentral, p *profile) error {
+	err := credentials(uc, p)
+	if err != nil {
+		return err
+	}
+	err = uc.Login()
+	if err != nil {
+		return err
 	}
-	//fmt.Printf("API Gateway: %s\nFirmware Management System: %s\n", uc.GW, uc.FMS)
+	log.Println("Logged In to uCentral")
+	err = uc.PopulateEndpoints()
+	if err != nil {
+		logout(uc)
+		return err
+	}
+	if *sessFlag {
+		return uc.SaveSession(sessionPath(p))
+	}
+	return nil
 }
 
 func logout(uc *tipWifi.UCentral) {
@@ -283,6 +387,154 @@ func logout(uc *tipWifi.UCentral) {
 	}
 }
 
+// serial returns the positional argument at index i validated as a Serial Number.
+func (ctx *context) serial(i int) (string, error) {
+	sn := strings.ToLower(ctx.fs.Arg(i))
+	if len(sn) != 12 {
+		return "", usagef("%s :Incorrect Device SN Length", sn)
+	}
+	return sn, nil
+}
+
+// render writes a Report to stdout in the -output format.
+func (ctx *context) render(r *tipWifi.Report) error {
+	return r.Write(os.Stdout, *outputFlag)
+}
+
+func validOutput(format string) bool {
+	if strings.HasPrefix(format, "template=") {
+		return true
+	}
+	return format != "template" && existsInList(format, tipWifi.OutputFormats)
+}
+
+const selectorHelp = `
+Selectors:
+  Whitespace separated terms, all of which a device must match. Commas
+  separate alternatives within a term. Quote selectors with several terms.
+  aabbccddeeff,112233*     serial numbers or globs
+  @devices.txt             serial numbers or globs read from a file
+  deviceType=edgecore_*    field matching a glob, != for not matching
+  notes~rma                field containing a string
+  notes~"sent for rma"     double quotes keep whitespace within a term
+  connected=true           FMS connection status
+  Fields: serial, deviceType, firmware, revision, manufacturer, venue, owner,
+  location, name, notes, connected
+`
+
+// devices returns the Serial Numbers chosen by the selector at index i. A
+// literal Serial Number is used as is, anything else is resolved on the GW and
+// previewed on stderr, and with -preview nothing is returned.
+func (ctx *context) devices(i int) ([]string, error) {
+	arg := ctx.fs.Arg(i)
+	if tipWifi.IsSerialNumber(arg) && !*prevFlag {
+		return []string{strings.ToLower(arg)}, nil
+	}
+	sel, err := tipWifi.ParseSelector(arg)
+	if err != nil {
+		return nil, usagef("%s", err)
+	}
+	s, err := ctx.uc.Select(sel)
+	if err != nil {
+		return nil, err
+	}
+	if *prevFlag {
+		return nil, ctx.render(s.Report())
+	}
+	if len(s.Entry) < 1 {
+		return nil, fmt.Errorf("%q :No devices matched", arg)
+	}
+	s.Report().Write(os.Stderr, "table")
+	ctx.selection = s
+	return s.SerialNumbers(), nil
+}
+
+// errCancelled is returned by confirm when the action is declined.
+var errCancelled = errors.New("Cancelled")
+
+// confirm guards a destructive action. It refuses to touch more than -max-devices,
+// and unless -yes or -dry-run is set, shows the name and location of each device
+// and asks before continuing. Confirmation is only asked for a single device
+// when always is set.
+func (ctx *context) confirm(action string, sns []string, always bool) error {
+	if len(sns) > *maxFlag {
+		return usagef("%s would touch %d devices, more than -max-devices %d", action, len(sns), *maxFlag)
+	}
+	if *yesFlag || ctx.uc.DryRun || len(sns) < 1 || (len(sns) == 1 && !always) {
+		return nil
+	}
+	if ctx.selection == nil {
+		sel, err := tipWifi.ParseSelector(strings.Join(sns, ","))
+		if err != nil {
+			return err
+		}
+		ctx.selection, err = ctx.uc.Select(sel)
+		if err != nil {
+			return err
+		}
+		ctx.selection.Report().Write(os.Stderr, "table")
+	}
+	if !isTerminal(os.Stdin) {
+		return usagef("%s requires confirmation, supply -yes to run without a terminal", action)
+	}
+	fmt.Fprintf(os.Stderr, "%s %d device(s)? [y/N] ", action, len(sns))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return errCancelled
+	}
+	return nil
+}
+
+// bulk runs the operation on the devices with the bulk executor, showing progress
+// when there is more than one, and renders the outcome of each device.
+func (ctx *context) bulk(name string, sns []string, op tipWifi.BulkOperation) error {
+	opts := &tipWifi.BulkOptions{
+		Workers:   *workFlag,
+		Timeout:   *timeFlag,
+		Interrupt: true,
+	}
+	if len(sns) > 1 {
+		opts.Progress = os.Stderr
+	}
+	br := tipWifi.RunBulk(name, sns, opts, op)
+	err := ctx.render(br.Report())
+	if err != nil {
+		return err
+	}
+	if *failFlag != "" && len(br.Failed()) > 0 {
+		err = br.WriteFailed(*failFlag)
+		if err != nil {
+			return err
+		}
+		log.Printf("Failed devices written to %s, retry them with @%s\n", *failFlag, *failFlag)
+	}
+	return br.Err()
+}
+
+func (ctx *context) flagString(name string) string {
+	return ctx.fs.Lookup(name).Value.String()
+}
+
+func (ctx *context) flagBool(name string) bool {
+	return ctx.fs.Lookup(name).Value.String() == "true"
+}
+
+func (ctx *context) flagInt(name string) int {
+	return ctx.fs.Lookup(name).Value.(flag.Getter).Get().(int)
+}
+
+// globalFlagSet reports whether the named global flag was supplied on the command line.
+func globalFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func existsInList(s string, l []string) bool {
 	for _, v := range l {
 		if strings.ToLower(s) == strings.ToLower(v) {
@@ -291,13 +543,3 @@ func existsInList(s string, l []string) bool {
 	}
 	return false
 }
-
-func parseArg(s string) int {
-	s = strings.ToLower(s)
-	for i := range validArgs {
-		if s == validArgs[i] {
-			return i
-		}
-	}
-	return -1
-}
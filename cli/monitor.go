@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var monitorCmd = &command{
+	name:    "monitor",
+	summary: "Poll the GW and FMS continuously and report device state changes",
+	flags: func(fs *flag.FlagSet) {
+		fs.Duration("interval", tipWifi.DefaultMonitorInterval, "Poll Interval")
+		alertFlags(fs)
+		fs.Bool("record", false, "Record status, statistics, health and events to the history store")
+		fs.String("store", "", "History Store Directory, def: one per profile in the user cache directory")
+		fs.Duration("stats-interval", tipWifi.DefaultStatsInterval, "Interval at which statistics and health are recorded")
+		fs.Bool("push", false, "Follow the GW notification stream to report connection changes as they happen")
+		fs.Duration("config-lag", tipWifi.DefaultConfigLag, "Report connected devices not downloading a configuration change for longer than this, 0 to disable")
+	},
+	run: monitorRun,
+}
+
+// monitorRun writes each Event to stdout, as JSON lines with -output json,
+// until interrupted or terminated.
+func monitorRun(ctx *context) error {
+	m := tipWifi.NewMonitor(ctx.uc, ctx.flagDuration("interval"))
+	m.ConfigLag = ctx.flagDuration("config-lag")
+	if ctx.flagBool("record") {
+		st, err := tipWifi.OpenStore(ctx.storePath())
+		if err != nil {
+			return err
+		}
+		m.Store = st
+		m.StatsInterval = ctx.flagDuration("stats-interval")
+		log.Printf("Recording history to %s\n", st.Path)
+	}
+	m.OnError = func(err error) {
+		log.Println(err)
+	}
+	m.Reauthenticate = func() error {
+		_, p, err := connection()
+		if err != nil {
+			return err
+		}
+		return login(ctx.uc, p)
+	}
+	if ctx.flagBool("push") {
+		ns := ctx.uc.NewNotificationStream(tipWifi.NotificationConnected, tipWifi.NotificationDisconnected)
+		// the polls renew the token, which the stream picks up on reconnect
+		ns.OnError = m.OnError
+		m.Notifications = ns
+	}
+	m.AddSink(&tipWifi.WriterSink{
+		W:    os.Stdout,
+		JSON: *outputFlag == "json",
+	})
+	sinks, err := alertSinks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range sinks {
+		m.AddSink(s)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		log.Println("Stopping monitor")
+		m.Stop()
+	}()
+	log.Printf("Monitoring every %s\n", ctx.flagDuration("interval"))
+	return m.Run()
+}
+
+// alertFlags adds the flags read by alertSinks.
+func alertFlags(fs *flag.FlagSet) {
+	fs.String("webhook", "", "Post alerts as JSON to this URL")
+	fs.String("slack", "", "Post alerts to this Slack-compatible incoming webhook URL")
+	fs.String("smtp", "", "Mail alerts through this SMTP server, host:port")
+	fs.String("smtp-user", "", "SMTP Username, the password is read from $TIPWIFI_SMTP_PASSWORD")
+	fs.String("mail-from", "tipwifi@localhost", "Sender of alert mails")
+	fs.String("mail-to", "", "Comma separated recipients of alert mails")
+	fs.String("template", "", "Go template rendering an event as the alert message")
+	fs.String("events", "", fmt.Sprintf("Comma separated event types to alert on %v, def: all", tipWifi.EventTypes))
+	fs.Duration("flap", 2*time.Minute, "Drop a disconnect alert if the device reconnects within this window")
+	fs.Duration("dedup", 15*time.Minute, "Drop alerts identical to one sent within this window")
+	fs.Int("rate", 30, "Most alerts sent per hour by each sink, 0 for no limit")
+}
+
+// alertSink combines the sinks of alertSinks into one, logging the errors of
+// each, or returns nil when no sink was requested.
+func alertSink(ctx *context) (tipWifi.Sink, error) {
+	sinks, err := alertSinks(ctx)
+	if err != nil || len(sinks) < 1 {
+		return nil, err
+	}
+	return tipWifi.SinkFunc(func(e *tipWifi.Event) error {
+		for _, s := range sinks {
+			if err := s.Send(e); err != nil {
+				log.Printf("%s %s: %s\n", e.SerialNumber, e.Type, err)
+			}
+		}
+		return nil
+	}), nil
+}
+
+// alertSinks builds the alert sinks requested by the alert flags, each wrapped
+// in its own AlertPolicy so that one sink's rate limit does not affect another.
+func alertSinks(ctx *context) ([]tipWifi.Sink, error) {
+	tmpl, err := tipWifi.ParseAlertTemplate(ctx.flagString("template"))
+	if err != nil {
+		return nil, usagef("-template: %s", err)
+	}
+	var types []tipWifi.EventType
+	for _, t := range strings.Split(ctx.flagString("events"), ",") {
+		if t == "" {
+			continue
+		}
+		et := tipWifi.EventType(strings.ToLower(t))
+		valid := false
+		for _, v := range tipWifi.EventTypes {
+			valid = valid || v == et
+		}
+		if !valid {
+			return nil, usagef("%s :Invalid Event Type, must be one of %v", t, tipWifi.EventTypes)
+		}
+		types = append(types, et)
+	}
+
+	var sinks []tipWifi.Sink
+	if url := ctx.flagString("webhook"); url != "" {
+		sinks = append(sinks, &tipWifi.WebhookSink{URL: url, Template: tmpl})
+	}
+	if url := ctx.flagString("slack"); url != "" {
+		sinks = append(sinks, &tipWifi.SlackSink{URL: url, Template: tmpl})
+	}
+	if addr := ctx.flagString("smtp"); addr != "" {
+		to := strings.Split(ctx.flagString("mail-to"), ",")
+		if to[0] == "" {
+			return nil, usagef("-smtp requires -mail-to")
+		}
+		sinks = append(sinks, &tipWifi.EmailSink{
+			Addr:     addr,
+			Username: ctx.flagString("smtp-user"),
+			Password: os.Getenv("TIPWIFI_SMTP_PASSWORD"),
+			From:     ctx.flagString("mail-from"),
+			To:       to,
+			Template: tmpl,
+		})
+	}
+	for i, s := range sinks {
+		sinks[i] = &tipWifi.AlertPolicy{
+			Sink:       s,
+			Types:      types,
+			FlapWindow: ctx.flagDuration("flap"),
+			Dedup:      ctx.flagDuration("dedup"),
+			RateLimit:  ctx.flagInt("rate"),
+			RatePeriod: time.Hour,
+			OnError: func(err error) {
+				log.Println(err)
+			},
+		}
+	}
+	return sinks, nil
+}
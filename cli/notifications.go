@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var notificationsCmd = &command{
+	name:    "notifications",
+	summary: "Stream the notifications pushed by the GW until interrupted",
+	flags: func(fs *flag.FlagSet) {
+		fs.String("types", "", fmt.Sprintf("Comma separated notification types to show %v, def: all", tipWifi.NotificationTypes))
+	},
+	run: notificationsRun,
+}
+
+// notificationsRun prints each Notification, as JSON lines with -output json.
+func notificationsRun(ctx *context) error {
+	var types []tipWifi.NotificationType
+	for _, t := range strings.Split(ctx.flagString("types"), ",") {
+		if t == "" {
+			continue
+		}
+		nt := tipWifi.NotificationType(strings.ToLower(t))
+		valid := false
+		for _, v := range tipWifi.NotificationTypes {
+			valid = valid || v == nt
+		}
+		if !valid {
+			return usagef("%s :Invalid Notification Type, must be one of %v", t, tipWifi.NotificationTypes)
+		}
+		types = append(types, nt)
+	}
+	ns := ctx.uc.NewNotificationStream(types...)
+	ns.OnError = func(err error) {
+		log.Println(err)
+	}
+	ns.Reauthenticate = func() error {
+		_, p, err := connection()
+		if err != nil {
+			return err
+		}
+		return login(ctx.uc, p)
+	}
+	ch := ns.Subscribe(64)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		log.Println("Stopping notification stream")
+		ns.Stop()
+	}()
+	go ns.Run()
+	for n := range ch {
+		if *outputFlag != "json" {
+			fmt.Println(n.GenerateDescription())
+			continue
+		}
+		data, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The config object is the CLI configuration file, which holds named profiles
+// for the controllers the CLI can connect to. It is read from
+// ~/.config/tipwifi/config.yaml unless -config is supplied, for example:
+//
+//	default-profile: lab
+//	profiles:
+//	  lab:
+//	    sec: lab.example.com:16001
+//	    username: tip@ucentral.com
+//	    password-env: TIPWIFI_LAB_PASSWORD
+//	  prod:
+//	    sec: sec.example.com:16001
+//	    username: noc@example.com
+//	    ca-bundle: /etc/ssl/example-ca.pem
+//	    password-file: ~/.config/tipwifi/prod.pw
+//	    password-prompt: true
+type config struct {
+	DefaultProfile string
+	Profiles       map[string]*profile
+}
+
+// The profile object describes a single controller connection. The password is
+// never stored in the profile itself, only where to obtain it from.
+type profile struct {
+	Name           string
+	SEC            string
+	Username       string
+	CABundle       string
+	PasswordEnv    string
+	PasswordFile   string
+	PasswordPrompt bool
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tipwifi", "config.yaml")
+}
+
+// loadConfig reads the configuration file, returning an empty config if the
+// file does not exist.
+func loadConfig(file string) (*config, error) {
+	cfg := &config{Profiles: make(map[string]*profile)}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tree, err := parseYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if v, ok := tree["default-profile"].(string); ok {
+		cfg.DefaultProfile = v
+	}
+	profiles, _ := tree["profiles"].(map[string]interface{})
+	for name, v := range profiles {
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: profile %q must be a mapping", file, name)
+		}
+		p := &profile{Name: name}
+		for key, val := range fields {
+			s, _ := val.(string)
+			switch key {
+			case "sec":
+				p.SEC = s
+			case "username":
+				p.Username = s
+			case "ca-bundle":
+				p.CABundle = expandHome(s)
+			case "password-env":
+				p.PasswordEnv = s
+			case "password-file":
+				p.PasswordFile = expandHome(s)
+			case "password-prompt":
+				p.PasswordPrompt, _ = strconv.ParseBool(s)
+			default:
+				return nil, fmt.Errorf("%s: profile %q has unknown key %q", file, name, key)
+			}
+		}
+		cfg.Profiles[name] = p
+	}
+	return cfg, nil
+}
+
+// selectProfile returns the named profile, or the default one when name is empty.
+// A nil profile is returned when the configuration defines no profiles at all.
+func (cfg *config) selectProfile(name string) (*profile, error) {
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	if name == "" {
+		if len(cfg.Profiles) == 0 {
+			return nil, nil
+		}
+		if len(cfg.Profiles) > 1 {
+			return nil, errors.New("Multiple profiles configured, select one with -profile or default-profile")
+		}
+		for _, p := range cfg.Profiles {
+			return p, nil
+		}
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown profile %q", name)
+	}
+	return p, nil
+}
+
+// password obtains the profile's password from its credential sources in
+// order: environment variable, password file, then an interactive prompt,
+// which is also used when the password file does not exist.
+func (p *profile) password() (string, error) {
+	if p.PasswordEnv != "" {
+		if pw := os.Getenv(p.PasswordEnv); pw != "" {
+			return pw, nil
+		}
+	}
+	if p.PasswordFile != "" {
+		fi, err := os.Stat(p.PasswordFile)
+		switch {
+		case os.IsNotExist(err) && p.PasswordPrompt:
+			// the prompt stands in for a password file not yet created
+			return promptPassword(fmt.Sprintf("Password for %s@%s: ", p.Username, p.Name))
+		case err != nil:
+			return "", err
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			return "", fmt.Errorf("%s must not be accessible by group or others", p.PasswordFile)
+		}
+		data, err := ioutil.ReadFile(p.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if p.PasswordPrompt {
+		return promptPassword(fmt.Sprintf("Password for %s@%s: ", p.Username, p.Name))
+	}
+	return "", nil
+}
+
+// promptPassword reads a line from the terminal with echo disabled.
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stty applies terminal settings to the controlling terminal on stdin.
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// parseYAML parses the small subset of YAML used by the configuration file:
+// nested mappings of scalar values, comments and optionally quoted strings.
+func parseYAML(data string) (map[string]interface{}, error) {
+	type level struct {
+		indent int
+		m      map[string]interface{}
+	}
+	root := make(map[string]interface{})
+	stack := []level{{-1, root}}
+	var err error
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if strings.Contains(line, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", n+1)
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		colon := strings.Index(trimmed, ":")
+		if colon < 1 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n+1)
+		}
+		key := strings.TrimSpace(trimmed[:colon])
+		val := strings.TrimSpace(trimmed[colon+1:])
+		for len(stack) > 1 && indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1].m
+		if val == "" {
+			child := make(map[string]interface{})
+			parent[key] = child
+			stack = append(stack, level{indent, child})
+			continue
+		}
+		parent[key], err = yamlScalar(val)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+	}
+	return root, nil
+}
+
+// yamlScalar returns the value of a plain, single or double quoted scalar.
+// Double quoted scalars take backslash escapes and single quoted ones a doubled
+// quote, anything after the closing quote must be a comment.
+func yamlScalar(val string) (string, error) {
+	if len(val) < 1 || (val[0] != '"' && val[0] != '\'') {
+		if i := strings.Index(val, " #"); i >= 0 {
+			val = strings.TrimSpace(val[:i])
+		}
+		return val, nil
+	}
+	q := val[0]
+	end := -1
+	for i := 1; i < len(val) && end < 0; i++ {
+		switch {
+		case q == '"' && val[i] == '\\':
+			i++
+		case val[i] == q && q == '\'' && i+1 < len(val) && val[i+1] == q:
+			i++
+		case val[i] == q:
+			end = i
+		}
+	}
+	if end < 0 {
+		return "", errors.New("unterminated quoted string")
+	}
+	if rest := strings.TrimSpace(val[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after quoted string", rest)
+	}
+	if q == '\'' {
+		return strings.Replace(val[1:end], "''", "'", -1), nil
+	}
+	s, err := strconv.Unquote(val[:end+1])
+	if err != nil {
+		return "", fmt.Errorf("invalid escape in %s", val[:end+1])
+	}
+	return s, nil
+}
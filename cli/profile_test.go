@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]interface{}
+		err  bool
+	}{
+		{"scalars", "a: 1\nb: two\n", map[string]interface{}{"a": "1", "b": "two"}, false},
+		{"comments and document start", "---\n# comment\na: 1 # trailing\n\n", map[string]interface{}{"a": "1"}, false},
+		{"quoted", "a: \"x # y\"\nb: 'single'\nc: \"tab\\there\"\n", map[string]interface{}{"a": "x # y", "b": "single", "c": "tab\there"}, false},
+		{"nested", "profiles:\n  lab:\n    sec: https://lab\n  prod:\n    sec: https://prod\ndefault: lab\n", map[string]interface{}{
+			"profiles": map[string]interface{}{
+				"lab":  map[string]interface{}{"sec": "https://lab"},
+				"prod": map[string]interface{}{"sec": "https://prod"},
+			},
+			"default": "lab",
+		}, false},
+		{"escaped quotes", `a: "say \"hi\"" # c` + "\nb: 'it''s'\n", map[string]interface{}{"a": `say "hi"`, "b": "it's"}, false},
+		{"crlf", "a: 1\r\nb: 2\r\n", map[string]interface{}{"a": "1", "b": "2"}, false},
+		{"empty mapping", "a:\n", map[string]interface{}{"a": map[string]interface{}{}}, false},
+		{"unterminated quote", "a: \"open\n", nil, true},
+		{"text after quote", "a: \"x\" y\n", nil, true},
+		{"bad escape", "a: \"\\q\"\n", nil, true},
+		{"tab indent", "a:\n\tb: 1\n", nil, true},
+		{"no key", "just text\n", nil, true},
+		{"missing key", ": value\n", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseYAML(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: parseYAML = %v, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseYAML: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseYAML = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var runCmd = &command{
+	name:      "run",
+	summary:   "Run a script of tipwifi commands on a single uCentral session",
+	argFields: []string{"Script File"},
+	flags: func(fs *flag.FlagSet) {
+		fs.Var(&scriptVars{}, "set", "Script variable as name=value, may be repeated")
+		fs.Bool("continue", false, "Start the script with on-error continue")
+	},
+}
+
+// runScript executes command lines, so it is attached at init to keep the
+// command tree free of an initialization cycle.
+func init() {
+	runCmd.run = runScript
+}
+
+const scriptHelp = `
+Scripts:
+  One command per line, without the leading tipwifi. Lines starting with #
+  are comments. $name or ${name} is replaced by a script variable, or by the
+  environment variable of that name.
+  set name=value           define a script variable
+  on-error continue|abort  keep going or stop after a failed command, def: abort
+`
+
+// scriptVars collects the -set flags of the run command.
+type scriptVars map[string]string
+
+func (sv *scriptVars) String() string {
+	return ""
+}
+
+func (sv *scriptVars) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("%s :Variable must be name=value", s)
+	}
+	if *sv == nil {
+		*sv = make(scriptVars)
+	}
+	(*sv)[s[:i]] = s[i+1:]
+	return nil
+}
+
+func runScript(ctx *context) error {
+	file := ctx.fs.Arg(0)
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	vars := make(scriptVars)
+	for k, v := range *ctx.fs.Lookup("set").Value.(*scriptVars) {
+		vars[k] = v
+	}
+	abort := !ctx.flagBool("continue")
+
+	steps, failed := 0, 0
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line, err = vars.expand(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", file, n, err)
+		}
+		args := splitArgs(line)
+		switch strings.ToLower(args[0]) {
+		case "set":
+			if len(args) != 2 {
+				return fmt.Errorf("%s:%d: set requires name=value", file, n)
+			}
+			err = vars.Set(args[1])
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s", file, n, err)
+			}
+			continue
+		case "on-error":
+			if len(args) != 2 || !existsInList(args[1], []string{"continue", "abort"}) {
+				return fmt.Errorf("%s:%d: on-error requires continue or abort", file, n)
+			}
+			abort = strings.EqualFold(args[1], "abort")
+			continue
+		case shellCmd.name:
+			return fmt.Errorf("%s:%d: shell cannot be run from a script", file, n)
+		}
+
+		steps++
+		log.Printf("[%d] %s:%d: %s\n", steps, file, n, line)
+		code := executeWith(ctx.uc, args)
+		if code == exitOK {
+			log.Printf("[%d] ok\n", steps)
+			continue
+		}
+		failed++
+		log.Printf("[%d] failed with exit code %d\n", steps, code)
+		if abort {
+			return fmt.Errorf("%s:%d: aborted after %d of %d step(s) failed", file, n, failed, steps)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	log.Printf("%s: %d step(s), %d failed\n", file, steps, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d step(s) failed", failed, steps)
+	}
+	return nil
+}
+
+// expand replaces $name and ${name} with script variables, falling back to the
+// environment, and refuses undefined variables rather than running a command
+// with an empty argument.
+func (sv scriptVars) expand(line string) (string, error) {
+	var missing []string
+	line = os.Expand(line, func(name string) string {
+		if v, ok := sv[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		missing = append(missing, name)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Undefined variable(s) %v", missing)
+	}
+	return line, nil
+}
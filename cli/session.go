@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var loginCmd = &command{
+	name:    "login",
+	summary: "Log in to the profile's controller and cache the session",
+	offline: true,
+	run: func(ctx *context) error {
+		uc, p, err := connection()
+		if err != nil {
+			return err
+		}
+		if !*sessFlag {
+			return usagef("login requires -session")
+		}
+		err = login(uc, p)
+		if err != nil {
+			return err
+		}
+		log.Printf("Session cached for profile %s until %s\n", p.Name, expiry(uc.OAuth2))
+		return nil
+	},
+}
+
+var logoutCmd = &command{
+	name:    "logout",
+	summary: "Delete the profile's token from the controller and the session cache",
+	offline: true,
+	run: func(ctx *context) error {
+		uc, p, err := connection()
+		if err != nil {
+			return err
+		}
+		file := sessionPath(p)
+		err = uc.RestoreSession(file)
+		if err == nil {
+			logout(uc)
+		}
+		return tipWifi.RemoveSession(file)
+	},
+}
+
+var whoamiCmd = &command{
+	name:    "whoami",
+	summary: "Show the cached session of the profile",
+	offline: true,
+	run: func(ctx *context) error {
+		uc, p, err := connection()
+		if err != nil {
+			return err
+		}
+		err = uc.RestoreSession(sessionPath(p))
+		if err != nil {
+			return err
+		}
+		// a token can be revoked before it expires, so confirm the SEC still accepts it
+		err = uc.PopulateEndpoints()
+		if err != nil {
+			return err
+		}
+		r := tipWifi.NewReport("Session", "profile", "username", "sec", "gw", "fms", "expires")
+		r.Add(p.Name, uc.OAuth2.Username, uc.SEC, uc.GW, uc.FMS, expiry(uc.OAuth2))
+		return ctx.render(r)
+	},
+}
+
+func expiry(o *tipWifi.OAuth2) string {
+	exp := o.ExpiresAt()
+	if exp.IsZero() {
+		return "unknown"
+	}
+	return exp.Format(time.RFC3339)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+const historySize = 500
+
+var shellCmd = &command{
+	name:    "shell",
+	summary: "Run commands interactively on a single uCentral session",
+}
+
+// runShell executes command lines, so it is attached at init to keep the
+// command tree free of an initialization cycle.
+func init() {
+	shellCmd.run = runShell
+}
+
+// shellBuiltins are handled by the shell itself rather than the command tree.
+var shellBuiltins = []string{"use", "refresh", "history", "help", "exit", "quit"}
+
+// The shell object holds the state of an interactive session: the current
+// device, the command history and the completion caches.
+type shell struct {
+	uc      *tipWifi.UCentral
+	in      *bufio.Reader
+	device  string // inserted where a command's Serial Number is missing
+	history []string
+	profile string
+	cache   *completionCache
+}
+
+func runShell(ctx *context) error {
+	sh := &shell{
+		uc:      ctx.uc,
+		in:      bufio.NewReader(os.Stdin),
+		profile: completionProfile(),
+	}
+	sh.loadHistory()
+	defer sh.saveHistory()
+	fmt.Println("Type help for commands, exit or Ctrl-D to leave")
+	for {
+		line, err := sh.readLine(sh.prompt())
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		sh.addHistory(line)
+		args := splitArgs(line)
+		switch strings.ToLower(args[0]) {
+		case "exit", "quit":
+			return nil
+		case "use":
+			sh.use(args[1:])
+		case "refresh":
+			sh.refresh()
+		case "history":
+			for i, h := range sh.history {
+				fmt.Printf("%4d  %s\n", i+1, h)
+			}
+		case "help":
+			cmd, path, _ := resolve(args[1:])
+			printHelp(path, cmd)
+			if cmd == commands {
+				fmt.Fprintln(os.Stderr, "\nShell Commands:")
+				fmt.Fprintln(os.Stderr, "  use <SN>     Set the current device, or clear it without SN")
+				fmt.Fprintln(os.Stderr, "  refresh      Reload the Serial Numbers and Device Types used for completion")
+				fmt.Fprintln(os.Stderr, "  history      Show the command history")
+				fmt.Fprintln(os.Stderr, "  exit         Leave the shell")
+			}
+		case shellCmd.name:
+			log.Println("Already in the shell")
+		default:
+			executeWith(sh.uc, sh.withDevice(args))
+		}
+	}
+}
+
+func (sh *shell) prompt() string {
+	if sh.device != "" {
+		return fmt.Sprintf("tipwifi (%s)> ", sh.device)
+	}
+	return "tipwifi> "
+}
+
+// use sets the current device after confirming it exists on the GW.
+func (sh *shell) use(args []string) {
+	if len(args) < 1 {
+		sh.device = ""
+		return
+	}
+	sn := strings.ToLower(args[0])
+	if len(sn) != 12 {
+		log.Printf("%s :Incorrect Device SN Length\n", sn)
+		return
+	}
+	dev, err := sh.uc.GetDevice(sn)
+	if err != nil {
+		log.Println(sn, err)
+		return
+	}
+	// the GW answers an unknown Serial Number with an empty Device
+	if dev.SerialNumber == "" {
+		log.Println(sn, ":SN Not Found")
+		return
+	}
+	sh.device = dev.SerialNumber
+	fmt.Printf("%s: %s, %s\n", dev.SerialNumber, dev.DeviceType, dev.Configuration.Unit.Location)
+}
+
+// withDevice inserts the current device as the first positional argument of
+// commands that take a Serial Number and were not given one.
+func (sh *shell) withDevice(args []string) []string {
+	cmd, path, rest := resolve(args)
+	if sh.device == "" || cmd.run == nil || len(cmd.argFields) < 1 || !strings.Contains(cmd.argFields[0], "Serial Number") {
+		return args
+	}
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	if fs.Parse(rest) != nil {
+		return args
+	}
+	missing := fs.NArg() < requiredArgs(cmd)
+	if cmd.argFields[0] == "Serial Number" && len(fs.Arg(0)) != 12 {
+		missing = true
+	}
+	if !missing {
+		return args
+	}
+	n := len(args) - fs.NArg()
+	out := append([]string{}, args[:n]...)
+	out = append(out, sh.device)
+	return append(out, args[n:]...)
+}
+
+// splitArgs splits a command line on spaces, keeping quoted strings together.
+func splitArgs(line string) (args []string) {
+	var cur strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// readLine reads a command line with editing, history and completion when
+// stdin is a terminal, and a plain line otherwise.
+func (sh *shell) readLine(prompt string) (string, error) {
+	state, err := sttyState()
+	if err != nil || stty("-icanon", "-echo", "-isig", "min", "1") != nil {
+		fmt.Print(prompt)
+		line, err := sh.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer stty(state)
+
+	var buf []rune
+	pos := 0
+	h := len(sh.history)
+	redraw := func() {
+		fmt.Printf("\r\033[K%s%s", prompt, string(buf))
+		if pos < len(buf) {
+			fmt.Printf("\033[%dD", len(buf)-pos)
+		}
+	}
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+	redraw()
+	for {
+		r, _, err := sh.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Print("\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			fmt.Print("^C\r\n")
+			setLine("")
+			h = len(sh.history)
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+		case '\t':
+			line := sh.complete(string(buf[:pos]))
+			buf = append([]rune(line), buf[pos:]...)
+			pos = len([]rune(line))
+		case 27: // escape sequences of the arrow, home, end and delete keys
+			if b, _ := sh.in.ReadByte(); b != '[' {
+				break
+			}
+			b, _ := sh.in.ReadByte()
+			switch b {
+			case 'A':
+				if h > 0 {
+					h--
+					setLine(sh.history[h])
+				}
+			case 'B':
+				if h < len(sh.history)-1 {
+					h++
+					setLine(sh.history[h])
+				} else {
+					h = len(sh.history)
+					setLine("")
+				}
+			case 'C':
+				if pos < len(buf) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(buf)
+			case '3':
+				sh.in.ReadByte() // trailing ~
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r >= ' ' {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		redraw()
+	}
+}
+
+// complete returns the line extended with the completion of its last word.
+// When several candidates remain they are listed below the prompt.
+func (sh *shell) complete(line string) string {
+	words := strings.Fields(line)
+	prefix := ""
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		prefix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	candidates := matchPrefix(sh.candidates(words, prefix), prefix)
+	if len(candidates) == 0 {
+		return line
+	}
+	base := line[:len(line)-len(prefix)]
+	if len(candidates) == 1 {
+		return base + candidates[0] + " "
+	}
+	common := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(prefix) {
+		return base + common
+	}
+	fmt.Printf("\r\n%s\r\n", strings.Join(candidates, "  "))
+	return line
+}
+
+// candidates returns the words that may follow the supplied complete words,
+// adding the shell's own commands to those of the command tree.
+func (sh *shell) candidates(words []string, prefix string) []string {
+	if len(words) > 0 && strings.EqualFold(words[0], "use") {
+		return sh.completions().SerialNumbers
+	}
+	list := candidates(words, prefix, sh.completions)
+	if len(words) == 0 {
+		list = append(list, shellBuiltins...)
+	}
+	return list
+}
+
+// completions returns the completion cache of the profile, refreshing it
+// when it has expired.
+func (sh *shell) completions() *completionCache {
+	if sh.cache != nil && !sh.cache.expired() {
+		return sh.cache
+	}
+	sh.cache = loadCompletionCache(sh.profile)
+	if sh.cache.expired() {
+		sh.refresh()
+	}
+	return sh.cache
+}
+
+func (sh *shell) refresh() {
+	cc, err := fetchCompletionCache(sh.uc, sh.profile)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	sh.cache = cc
+}
+
+func (sh *shell) addHistory(line string) {
+	if len(sh.history) > 0 && sh.history[len(sh.history)-1] == line {
+		return
+	}
+	sh.history = append(sh.history, line)
+	if len(sh.history) > historySize {
+		sh.history = sh.history[len(sh.history)-historySize:]
+	}
+}
+
+func historyPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tipwifi", "history")
+}
+
+func (sh *shell) loadHistory() {
+	data, err := ioutil.ReadFile(historyPath())
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			sh.addHistory(line)
+		}
+	}
+}
+
+func (sh *shell) saveHistory() {
+	file := historyPath()
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(file, []byte(strings.Join(sh.history, "\n")+"\n"), 0600)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// sttyState returns the current terminal settings in a form stty can restore.
+func sttyState() (string, error) {
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
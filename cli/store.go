@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+var storeCmd = &command{
+	name:    "store",
+	summary: "Query the local history recorded by monitor -record",
+	sub: []*command{
+		{
+			name:      "history",
+			summary:   "Show every record of a device",
+			argFields: []string{"Serial Number"},
+			flags:     storeFlags,
+			run:       storeHistory,
+			offline:   true,
+		},
+		{
+			name:      "uptime",
+			summary:   "Report the share of time each device was connected, least available first",
+			argFields: []string{"<Serial Number>", "<more Serial Numbers>"},
+			flags: func(fs *flag.FlagSet) {
+				storeFlags(fs)
+				fs.Duration("max-gap", 2*tipWifi.DefaultStatsInterval, "Leave out gaps between records longer than this, such as while the monitor was stopped, 0 for none")
+			},
+			run:     storeUptime,
+			offline: true,
+		},
+		{
+			name:      "trend",
+			summary:   "Summarise a metric of a device over time",
+			argFields: []string{"Serial Number", "Metric"},
+			flags: func(fs *flag.FlagSet) {
+				storeFlags(fs)
+				fs.Duration("bucket", time.Hour, "Period summarised by each row")
+			},
+			run:     storeTrend,
+			offline: true,
+		},
+		{
+			name:    "compact",
+			summary: "Remove the days of history older than -keep from the store",
+			flags: func(fs *flag.FlagSet) {
+				fs.String("store", "", "History Store Directory, def: one per profile in the user cache directory")
+				fs.Duration("keep", 90*24*time.Hour, "Keep records newer than this")
+			},
+			run:     storeCompact,
+			offline: true,
+		},
+	},
+}
+
+func storeFlags(fs *flag.FlagSet) {
+	fs.String("store", "", "History Store Directory, def: one per profile in the user cache directory")
+	fs.String("from", "24h", "Start of the range, a time (RFC3339 or 2006-01-02) or a duration ago, empty for all")
+	fs.String("to", "", "End of the range, as -from, empty for now")
+}
+
+// storePath returns the -store directory, by default one per profile as the Serial
+// Numbers of different controllers are unrelated.
+func (ctx *context) storePath() string {
+	if path := ctx.flagString("store"); path != "" {
+		return path
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tipwifi", "store", completionProfile())
+}
+
+// parseTime accepts a time in RFC3339 or date form, or a duration before now.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, usagef("%s :Invalid Time, must be RFC3339, 2006-01-02 or a duration ago", s)
+}
+
+// store opens the -store directory and parses the -from and -to range.
+func (ctx *context) store() (st *tipWifi.Store, from, to time.Time, err error) {
+	path := ctx.storePath()
+	if _, err = os.Stat(path); err != nil {
+		return nil, from, to, fmt.Errorf("%s :No history recorded, run monitor -record first", path)
+	}
+	if ctx.fs.Lookup("from") != nil {
+		if from, err = parseTime(ctx.flagString("from")); err != nil {
+			return
+		}
+		if to, err = parseTime(ctx.flagString("to")); err != nil {
+			return
+		}
+	}
+	st, err = tipWifi.OpenStore(path)
+	return
+}
+
+func storeHistory(ctx *context) error {
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return err
+	}
+	st, from, to, err := ctx.store()
+	if err != nil {
+		return err
+	}
+	r, err := st.HistoryReport(sn, from, to)
+	if err != nil {
+		return err
+	}
+	return ctx.render(r)
+}
+
+func storeUptime(ctx *context) error {
+	var sns []string
+	for i := 0; i < ctx.fs.NArg(); i++ {
+		sn, err := ctx.serial(i)
+		if err != nil {
+			return err
+		}
+		sns = append(sns, sn)
+	}
+	st, from, to, err := ctx.store()
+	if err != nil {
+		return err
+	}
+	list, err := st.Uptime(from, to, ctx.flagDuration("max-gap"), sns...)
+	if err != nil {
+		return err
+	}
+	return ctx.render(tipWifi.UptimeReport(list))
+}
+
+func storeTrend(ctx *context) error {
+	sn, err := ctx.serial(0)
+	if err != nil {
+		return err
+	}
+	metric := ctx.fs.Arg(1)
+	if !existsInList(metric, tipWifi.Metrics) {
+		return usagef("%s :Invalid Metric, must be one of %v", metric, tipWifi.Metrics)
+	}
+	st, from, to, err := ctx.store()
+	if err != nil {
+		return err
+	}
+	tr, err := st.Trend(sn, metric, from, to, ctx.flagDuration("bucket"))
+	if err != nil {
+		return err
+	}
+	return ctx.render(tr.Report())
+}
+
+func storeCompact(ctx *context) error {
+	st, _, _, err := ctx.store()
+	if err != nil {
+		return err
+	}
+	n, err := st.Compact(time.Now().Add(-ctx.flagDuration("keep")))
+	if err != nil {
+		return err
+	}
+	r := tipWifi.NewReport("Compacted", "store", "removed")
+	r.Add(st.Path, n)
+	return ctx.render(r)
+}
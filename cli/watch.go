@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/lindsaybb/tipWifi"
+)
+
+// watchHistory is the number of recent transitions kept below the watch table.
+const watchHistory = 15
+
+// ANSI colors used to highlight transitions on a terminal.
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[1;33m"
+	colorReset  = "\033[0m"
+)
+
+func watchFlag(fs *flag.FlagSet) {
+	fs.Duration("watch", 0, "Poll at this interval, redrawing and highlighting transitions until Ctrl-C")
+}
+
+func (ctx *context) flagDuration(name string) time.Duration {
+	return ctx.fs.Lookup(name).Value.(flag.Getter).Get().(time.Duration)
+}
+
+// watch polls a Snapshot of the devices, or of the fleet when none are given,
+// until interrupted. With table output the screen is redrawn each time with
+// changed devices and recent transitions highlighted, any other output format
+// receives the first Snapshot followed by each batch of transitions.
+func (ctx *context) watch(sns ...string) error {
+	interval := ctx.flagDuration("watch")
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	table := *outputFlag == "table"
+	color := isTerminal(os.Stdout)
+	var prev *tipWifi.Snapshot
+	var recent []*tipWifi.Transition
+	for {
+		s, err := ctx.uc.TakeSnapshot(sns...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", time.Now().Format("15:04:05"), err)
+		} else {
+			changes := s.Diff(prev)
+			recent = append(recent, changes...)
+			if len(recent) > watchHistory {
+				recent = recent[len(recent)-watchHistory:]
+			}
+			switch {
+			case table:
+				drawWatch(ctx.path, interval, s, changes, recent, color)
+			case prev == nil:
+				err = ctx.render(s.Report())
+			case len(changes) > 0:
+				err = ctx.render(tipWifi.TransitionReport(changes))
+			}
+			if err != nil {
+				return err
+			}
+			prev = s
+		}
+		select {
+		case <-sig:
+			fmt.Println()
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// drawWatch clears the terminal and prints the Snapshot table followed by the
+// recent transitions, highlighting the rows of devices that just changed.
+func drawWatch(path string, interval time.Duration, s *tipWifi.Snapshot, changes, recent []*tipWifi.Transition, color bool) {
+	changed := make(map[string]bool)
+	for _, t := range changes {
+		changed[t.SerialNumber] = true
+	}
+	var buf bytes.Buffer
+	s.Report().Write(&buf, "table")
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	sns := s.SerialNumbers()
+
+	if color {
+		fmt.Print("\033[H\033[2J")
+	}
+	fmt.Printf("Every %s: %s, Ctrl-C to stop\n\n", interval, path)
+	for i, line := range lines {
+		// the title and header lines precede one line per device
+		if n := i - 2; color && n >= 0 && n < len(sns) && changed[sns[n]] {
+			line = colorYellow + line + colorReset
+		}
+		fmt.Println(line)
+	}
+	if len(recent) > 0 {
+		fmt.Println("\nTransitions:")
+	}
+	for _, t := range recent {
+		desc := t.GenerateDescription()
+		if color {
+			desc = transitionColor(t) + desc + colorReset
+		}
+		fmt.Println("  " + desc)
+	}
+}
+
+func transitionColor(t *tipWifi.Transition) string {
+	switch {
+	case t.Field == tipWifi.FieldRemoved || t.To == "DOWN":
+		return colorRed
+	case t.Field == tipWifi.FieldAdded || t.To == "UP":
+		return colorGreen
+	}
+	return colorYellow
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
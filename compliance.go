@@ -0,0 +1,180 @@
+package tipWifi
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The ComplianceReport object groups every FMS device by DeviceType and compares
+// its running revision with the latest image available for that type.
+type ComplianceReport struct {
+	Generated int                `json:"generated"`
+	Total     int                `json:"total"`
+	Current   int                `json:"current"`
+	Outdated  int                `json:"outdated"`
+	Unknown   int                `json:"unknown"`
+	Groups    []*ComplianceGroup `json:"groups"`
+}
+
+// The ComplianceGroup object summarizes the devices of a single DeviceType.
+type ComplianceGroup struct {
+	DeviceType     string             `json:"deviceType"`
+	LatestRevision string             `json:"latestRevision"`
+	Current        int                `json:"current"`
+	Outdated       int                `json:"outdated"`
+	Unknown        int                `json:"unknown"`
+	Connected      int                `json:"connected"`
+	Devices        []*ComplianceEntry `json:"devices"`
+}
+
+// The ComplianceEntry object describes the firmware compliance of one device.
+// ReleasesBehind and ImageAgeDays are -1 when the running revision is not in
+// the FMS registry.
+type ComplianceEntry struct {
+	SerialNumber   string `json:"serialNumber"`
+	DeviceType     string `json:"deviceType"`
+	Revision       string `json:"revision"`
+	LatestRevision string `json:"latestRevision"`
+	ReleasesBehind int    `json:"releasesBehind"`
+	ImageDate      int    `json:"imageDate"`
+	ImageAgeDays   int    `json:"imageAgeDays"`
+	Connected      bool   `json:"connected"`
+}
+
+// GenerateComplianceReport combines the FMS device list with the firmware registry
+// of each DeviceType present to produce a ComplianceReport.
+func (uc *UCentral) GenerateComplianceReport() (*ComplianceReport, error) {
+	fwds, err := uc.GetAllFirmwareDevices()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cr := &ComplianceReport{Generated: int(now.Unix())}
+	groups := make(map[string]*ComplianceGroup)
+	registry := make(map[string]*Firmwares)
+	for i := 0; i < len(fwds.Entry); i++ {
+		fwd := fwds.Entry[i]
+		cg, ok := groups[fwd.DeviceType]
+		if !ok {
+			cg = &ComplianceGroup{DeviceType: fwd.DeviceType}
+			latest, err := uc.GetLatestFirmwareByDevice(fwd.DeviceType)
+			if err == nil {
+				cg.LatestRevision = latest.Revision
+			}
+			fws, err := uc.GetFirmwareListByDevice(fwd.DeviceType)
+			if err != nil {
+				fws = &Firmwares{}
+			}
+			fws.SortByImageDate()
+			registry[fwd.DeviceType] = fws
+			groups[fwd.DeviceType] = cg
+			cr.Groups = append(cr.Groups, cg)
+		}
+		ce := &ComplianceEntry{
+			SerialNumber:   fwd.SerialNumber,
+			DeviceType:     fwd.DeviceType,
+			Revision:       fwd.Revision,
+			LatestRevision: cg.LatestRevision,
+			ReleasesBehind: -1,
+			ImageAgeDays:   -1,
+			Connected:      fwd.IsConnected(),
+		}
+		fws := registry[fwd.DeviceType]
+		for n := 0; n < len(fws.Entry); n++ {
+			if fws.Entry[n].Revision == fwd.Revision {
+				// entries are sorted newest first, so the index is the number of newer images
+				ce.ReleasesBehind = n
+				ce.ImageDate = fws.Entry[n].ImageDate
+				ce.ImageAgeDays = int(now.Sub(time.Unix(int64(ce.ImageDate), 0)).Hours() / 24)
+				break
+			}
+		}
+		switch {
+		case cg.LatestRevision != "" && ce.Revision == cg.LatestRevision:
+			ce.ReleasesBehind = 0
+			cg.Current++
+		case ce.ReleasesBehind < 0 || cg.LatestRevision == "":
+			cg.Unknown++
+		default:
+			cg.Outdated++
+		}
+		if ce.Connected {
+			cg.Connected++
+		}
+		cg.Devices = append(cg.Devices, ce)
+	}
+	sort.Slice(cr.Groups, func(a, b int) bool {
+		return cr.Groups[a].DeviceType < cr.Groups[b].DeviceType
+	})
+	for _, cg := range cr.Groups {
+		cr.Total += len(cg.Devices)
+		cr.Current += cg.Current
+		cr.Outdated += cg.Outdated
+		cr.Unknown += cg.Unknown
+	}
+	return cr, nil
+}
+
+// GenerateSummary returns the fleet-wide compliance counts in a printable form.
+func (cr *ComplianceReport) GenerateSummary() []string {
+	desc := fmt.Sprintf("Devices: %d, ", cr.Total)
+	desc += fmt.Sprintf("Current: %d, Outdated: %d, Unknown: %d, ", cr.Current, cr.Outdated, cr.Unknown)
+	return []string{desc}
+}
+
+// GenerateList returns the group summary followed by one entry per device in a printable form.
+func (cg *ComplianceGroup) GenerateList() (list []string) {
+	desc := fmt.Sprintf("Latest: %s, ", cg.LatestRevision)
+	desc += fmt.Sprintf("Current: %d, Outdated: %d, Unknown: %d, Connected: %d/%d, ", cg.Current, cg.Outdated, cg.Unknown, cg.Connected, len(cg.Devices))
+	list = append(list, desc)
+	for i := 0; i < len(cg.Devices); i++ {
+		list = append(list, cg.Devices[i].GenerateDescription())
+	}
+	return list
+}
+
+// GenerateDescription returns a string of concatenated values describing the ComplianceEntry object.
+func (ce *ComplianceEntry) GenerateDescription() string {
+	desc := fmt.Sprintf("%s: %s, ", ce.SerialNumber, ce.Revision)
+	if ce.ReleasesBehind < 0 {
+		desc += "Behind: unknown, "
+	} else {
+		desc += fmt.Sprintf("Behind: %d, ", ce.ReleasesBehind)
+	}
+	if ce.ImageAgeDays < 0 {
+		desc += "Image Age: unknown, "
+	} else {
+		desc += fmt.Sprintf("Image Age: %dd, ", ce.ImageAgeDays)
+	}
+	if ce.Connected {
+		desc += "UP, "
+	} else {
+		desc += "DOWN, "
+	}
+	return desc
+}
+
+// Report returns a Report with one row per device, grouped by DeviceType.
+func (cr *ComplianceReport) Report() *Report {
+	r := NewReport(cr.GenerateSummary()[0], "deviceType", "serialNumber", "revision", "latestRevision", "releasesBehind", "imageDate", "imageAgeDays", "connected")
+	for _, cg := range cr.Groups {
+		for _, ce := range cg.Devices {
+			var date, age interface{} = ce.ImageDate, ce.ImageAgeDays
+			if ce.ImageAgeDays < 0 {
+				date, age = "", "unknown"
+			}
+			r.Add(ce.DeviceType, ce.SerialNumber, ce.Revision, ce.LatestRevision, ce.ReleasesBehind, date, age, ce.Connected)
+		}
+	}
+	return r
+}
+
+// GroupReport returns a Report with the summary counts of each DeviceType.
+func (cr *ComplianceReport) GroupReport() *Report {
+	r := NewReport(cr.GenerateSummary()[0], "deviceType", "latestRevision", "devices", "current", "outdated", "unknown", "connected")
+	for _, cg := range cr.Groups {
+		r.Add(cg.DeviceType, cg.LatestRevision, len(cg.Devices), cg.Current, cg.Outdated, cg.Unknown, cg.Connected)
+	}
+	return r
+}
@@ -0,0 +1,178 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The ConfigDifference object is a single difference between two configurations,
+// addressed by its path within the configuration. From and To hold JSON values.
+type ConfigDifference struct {
+	Change string // "+" (added), "-" (removed) or "~" (changed)
+	Path   string
+	From   string
+	To     string
+}
+
+// String returns the difference as a single line, ex: "~ radios[1].channel: 36 -> 44".
+func (d *ConfigDifference) String() string {
+	switch d.Change {
+	case "+":
+		return fmt.Sprintf("+ %s: %s", d.Path, d.To)
+	case "-":
+		return fmt.Sprintf("- %s: %s", d.Path, d.From)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", d.Path, d.From, d.To)
+}
+
+// CompareConfiguration compares two JSON configurations and returns one
+// ConfigDifference per added, removed or changed value.
+// The "uuid" assigned by the GW is ignored.
+func CompareConfiguration(from, to []byte) ([]*ConfigDifference, error) {
+	var a, b interface{}
+	err := json.Unmarshal(from, &a)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(to, &b)
+	if err != nil {
+		return nil, err
+	}
+	if am, ok := a.(map[string]interface{}); ok {
+		delete(am, "uuid")
+	}
+	if bm, ok := b.(map[string]interface{}); ok {
+		delete(bm, "uuid")
+	}
+	var list []*ConfigDifference
+	diffValue("", a, b, &list)
+	return list, nil
+}
+
+// DiffConfiguration compares two JSON configurations and returns one line per
+// difference, addressed by its path within the configuration and prefixed by
+// "+" (added), "-" (removed) or "~" (changed), ex: "~ radios[1].channel: 36 -> 44".
+// The "uuid" assigned by the GW is ignored.
+func DiffConfiguration(from, to []byte) ([]string, error) {
+	diffs, err := CompareConfiguration(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, d := range diffs {
+		list = append(list, d.String())
+	}
+	return list, nil
+}
+
+// ConfigDifferenceReport returns a Report with one row per ConfigDifference.
+func ConfigDifferenceReport(list []*ConfigDifference) *Report {
+	r := NewReport(fmt.Sprintf("%d difference(s)", len(list)), "change", "path", "from", "to")
+	for _, d := range list {
+		r.Add(d.Change, d.Path, d.From, d.To)
+	}
+	return r
+}
+
+// ConfigurationReport returns a Report of a JSON configuration with one row per
+// value, addressed by its path within the configuration.
+func ConfigurationReport(cfg []byte) (*Report, error) {
+	var v interface{}
+	err := json.Unmarshal(cfg, &v)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReport("Configuration", "path", "value")
+	flattenValue("", v, r)
+	return r, nil
+}
+
+func flattenValue(path string, v interface{}, r *Report) {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			flattenValue(p, vv[k], r)
+		}
+		return
+	case []interface{}:
+		for i := 0; i < len(vv); i++ {
+			flattenValue(fmt.Sprintf("%s[%d]", path, i), vv[i], r)
+		}
+		return
+	}
+	data, _ := json.Marshal(v)
+	r.Add(path, string(data))
+}
+
+func diffValue(path string, a, b interface{}, list *[]*ConfigDifference) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range av {
+			keys[k] = true
+		}
+		for k := range bv {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			diffValue(p, av[k], bv[k], list)
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(av)
+		if len(bv) > n {
+			n = len(bv)
+		}
+		for i := 0; i < n; i++ {
+			var ai, bi interface{}
+			if i < len(av) {
+				ai = av[i]
+			}
+			if i < len(bv) {
+				bi = bv[i]
+			}
+			diffValue(fmt.Sprintf("%s[%d]", path, i), ai, bi, list)
+		}
+		return
+	}
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	if string(aj) == string(bj) {
+		return
+	}
+	switch {
+	case a == nil:
+		*list = append(*list, &ConfigDifference{Change: "+", Path: path, To: string(bj)})
+	case b == nil:
+		*list = append(*list, &ConfigDifference{Change: "-", Path: path, From: string(aj)})
+	default:
+		*list = append(*list, &ConfigDifference{Change: "~", Path: path, From: string(aj), To: string(bj)})
+	}
+}
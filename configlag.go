@@ -0,0 +1,122 @@
+package tipWifi
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The ConfigLagEntry object describes a device that has not downloaded the
+// latest change to its configuration.
+type ConfigLagEntry struct {
+	SerialNumber string
+	DeviceType   string
+	UUID         int
+	Connected    bool
+	LastChange   time.Time
+	LastDownload time.Time // zero if the device never downloaded a configuration
+	Lag          time.Duration
+}
+
+// Diagnosis explains the lag from the connection status of the device.
+func (e *ConfigLagEntry) Diagnosis() string {
+	if e.Connected {
+		return "Connected but not applying its configuration, re-push it"
+	}
+	return "Disconnected, the configuration is downloaded on reconnect"
+}
+
+// The ConfigLagReport object lists the devices whose configuration download
+// lags the last change by more than the Threshold, longest lag first.
+type ConfigLagReport struct {
+	Threshold time.Duration
+	Taken     time.Time
+	Entry     []*ConfigLagEntry
+}
+
+// CheckConfigLag compares the last configuration change and download of the
+// supplied devices, or of every device when none are supplied, and reports
+// those lagging by more than the threshold along with their FMS status.
+func (uc *UCentral) CheckConfigLag(threshold time.Duration, sns ...string) (*ConfigLagReport, error) {
+	devs, err := uc.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	fwds, err := uc.GetAllFirmwareDevices()
+	if err != nil {
+		return nil, err
+	}
+	connected := make(map[string]bool)
+	for i := 0; i < len(fwds.Entry); i++ {
+		connected[fwds.Entry[i].SerialNumber] = fwds.Entry[i].IsConnected()
+	}
+	wanted := make(map[string]bool)
+	for _, sn := range sns {
+		wanted[sn] = true
+	}
+	r := &ConfigLagReport{
+		Threshold: threshold,
+		Taken:     time.Now(),
+	}
+	for i := 0; i < len(devs.Entry); i++ {
+		dev := devs.Entry[i]
+		if len(wanted) > 0 && !wanted[dev.SerialNumber] {
+			continue
+		}
+		lag := ConfigLag(dev, r.Taken)
+		if lag <= threshold || lag == 0 {
+			continue
+		}
+		e := &ConfigLagEntry{
+			SerialNumber: dev.SerialNumber,
+			DeviceType:   dev.DeviceType,
+			UUID:         dev.UUID,
+			Connected:    connected[dev.SerialNumber],
+			LastChange:   time.Unix(int64(dev.LastConfigurationChange), 0),
+			Lag:          lag,
+		}
+		if dev.LastConfigurationDownload > 0 {
+			e.LastDownload = time.Unix(int64(dev.LastConfigurationDownload), 0)
+		}
+		r.Entry = append(r.Entry, e)
+	}
+	sort.SliceStable(r.Entry, func(a, b int) bool {
+		return r.Entry[a].Lag > r.Entry[b].Lag
+	})
+	return r, nil
+}
+
+// SerialNumbers returns the Serial Numbers of the lagging devices that are
+// connected, which are the ones a re-push can reach.
+func (r *ConfigLagReport) SerialNumbers() (list []string) {
+	for _, e := range r.Entry {
+		if e.Connected {
+			list = append(list, e.SerialNumber)
+		}
+	}
+	return list
+}
+
+// Report returns a Report with one row per lagging device.
+func (r *ConfigLagReport) Report() *Report {
+	title := fmt.Sprintf("Configuration lagging by more than %s: %d device(s), %d connected", r.Threshold, len(r.Entry), len(r.SerialNumbers()))
+	rep := NewReport(title, "serialNumber", "deviceType", "uuid", "status", "lastChange", "lastDownload", "lag", "diagnosis")
+	for _, e := range r.Entry {
+		download := "never"
+		if !e.LastDownload.IsZero() {
+			download = e.LastDownload.Format(time.RFC3339)
+		}
+		rep.Add(e.SerialNumber, e.DeviceType, e.UUID, upDown(e.Connected), e.LastChange.Format(time.RFC3339), download, e.Lag.Round(time.Minute).String(), e.Diagnosis())
+	}
+	return rep
+}
+
+// RepushConfiguration pushes the configuration the GW holds for the device
+// again, so that a device that missed the change is sent it once more.
+func (uc *UCentral) RepushConfiguration(sn string) error {
+	cfg, err := uc.GetDeviceConfiguration(sn)
+	if err != nil {
+		return err
+	}
+	return uc.ConfigureDevice(sn, cfg)
+}
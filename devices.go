@@ -37,6 +37,7 @@ var DeviceInfo = []string{
 	"stats",        //
 	"logs",         //
 	"health",       //
+	"radios",
 }
 
 // The DeviceInfo list contains valid commands that can be applied to a Device object
@@ -210,6 +211,8 @@ func (dev *Device) ListInfo(info string) []string {
 	switch {
 	case info == "interfaces":
 		return dev.GenerateInterfaceReport()
+	case info == "radios":
+		return dev.GenerateRadioReport()
 	case info == "configuration":
 		//
 	case info == "capabilities":
@@ -265,3 +268,73 @@ func (dev *Device) GenerateRadioReport() (list []string) {
 	}
 	return list
 }
+
+// The HealthChecks object contains a list of the GW's HealthCheck object.
+type HealthChecks struct {
+	SerialNumber string         `json:"serialNumber"`
+	Entry        []*HealthCheck `json:"values"`
+}
+
+// The HealthCheck object is the periodic self-assessment reported by a device,
+// where Sanity is a value between 0-100 indicating how healthy the device thinks it is.
+type HealthCheck struct {
+	UUID     int         `json:"UUID"`
+	Recorded int         `json:"recorded"`
+	Sanity   int         `json:"sanity"`
+	Values   interface{} `json:"values"`
+}
+
+// StatusReport returns a Report of the connection status and revision of each FirmwareDevice.
+func (fwds *FirmwareDevices) StatusReport() *Report {
+	r := NewReport("Status", "serialNumber", "deviceType", "status", "revision", "lastUpdate")
+	for i := 0; i < len(fwds.Entry); i++ {
+		status := "DOWN"
+		if fwds.Entry[i].IsConnected() {
+			status = "UP"
+		}
+		r.Add(fwds.Entry[i].SerialNumber, fwds.Entry[i].DeviceType, status, fwds.Entry[i].Revision, fwds.Entry[i].LastUpdate)
+	}
+	return r
+}
+
+// Report returns a Report of the requested Info for every Device, see Device.Report.
+func (devs *Devices) Report(info string) *Report {
+	r := (&Device{}).Report(info)
+	r.Rows = nil
+	for i := 0; i < len(devs.Entry); i++ {
+		r.Append(devs.Entry[i].Report(info))
+	}
+	return r
+}
+
+// Report returns the requested Info of the Device as a Report. Like ListInfo,
+// an empty string returns the 'generic' description.
+func (dev *Device) Report(info string) *Report {
+	switch info {
+	case "interfaces":
+		r := NewReport("Interfaces", "serialNumber", "name", "role", "ipv4", "ipv6", "ssids")
+		for _, iface := range dev.Configuration.Interfaces {
+			var ssids []string
+			for _, ssid := range iface.Ssids {
+				ssids = append(ssids, ssid.Name)
+			}
+			r.Add(dev.SerialNumber, iface.Name, iface.Role, iface.Ipv4.Addressing, iface.Ipv6.Addressing, ssids)
+		}
+		return r
+	case "radios":
+		r := NewReport("Radios", "serialNumber", "band", "channel", "channelWidth", "channelMode", "txPower")
+		for _, radio := range dev.Configuration.Radios {
+			r.Add(dev.SerialNumber, radio.Band, radio.Channel, radio.ChannelWidth, radio.ChannelMode, radio.TxPower)
+		}
+		return r
+	case "configuration":
+		r := NewReport("Configuration", "serialNumber", "uuid", "name", "location", "timezone", "lastConfigurationChange", "lastConfigurationDownload")
+		r.Add(dev.SerialNumber, dev.Configuration.UUID, dev.Configuration.Unit.Name, dev.Configuration.Unit.Location, dev.Configuration.Unit.Timezone, dev.LastConfigurationChange, dev.LastConfigurationDownload)
+		return r
+	}
+	r := NewReport("Devices", "serialNumber", "deviceType", "manufacturer", "macAddress", "firmware", "uuid", "venue", "owner", "location")
+	if dev.SerialNumber != "" {
+		r.Add(dev.SerialNumber, dev.DeviceType, dev.Manufacturer, dev.MacAddress, dev.Firmware, dev.UUID, dev.Venue, dev.Owner, dev.Location)
+	}
+	return r
+}
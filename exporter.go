@@ -0,0 +1,368 @@
+package tipWifi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultExporterInterval is how often an Exporter refreshes when no Interval is set.
+const DefaultExporterInterval = time.Minute
+
+// The Exporter object serves fleet metrics in the Prometheus text exposition
+// format. Metrics are collected from the GW and FMS at an Interval rather than
+// on each scrape, so that any number of scrapers share one set of requests;
+// a scrape returns the metrics of the most recent successful refresh together
+// with the Exporter's own refresh duration and error metrics.
+type Exporter struct {
+	UC       *UCentral
+	Interval time.Duration
+	Workers  int             // devices queried at once for health checks and statistics, def: 8
+	OnError  func(err error) // receives refresh errors, def: ignored
+
+	// Reauthenticate is called before a refresh once the OAuth2 token has
+	// expired, as a long running Exporter outlives the token it started with.
+	Reauthenticate func() error
+
+	mu          sync.Mutex
+	fleet       []byte // device metrics of the last successful refresh
+	up          bool
+	refreshes   int
+	errors      map[string]int // failed requests by source
+	duration    time.Duration
+	lastSuccess time.Time
+	stop        chan struct{}
+	once        sync.Once
+}
+
+// NewExporter returns an Exporter of the UCentral refreshing at the supplied interval.
+func NewExporter(uc *UCentral, interval time.Duration) *Exporter {
+	return &Exporter{
+		UC:       uc,
+		Interval: interval,
+	}
+}
+
+// Stop ends Run after its current refresh.
+func (ex *Exporter) Stop() {
+	ex.once.Do(func() {
+		close(ex.done())
+	})
+}
+
+func (ex *Exporter) done() chan struct{} {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.stop == nil {
+		ex.stop = make(chan struct{})
+	}
+	return ex.stop
+}
+
+// Run refreshes until Stop is called.
+func (ex *Exporter) Run() error {
+	interval := ex.Interval
+	if interval <= 0 {
+		interval = DefaultExporterInterval
+	}
+	stop := ex.done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		err := ex.Refresh()
+		if err != nil && ex.OnError != nil {
+			ex.OnError(err)
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// The fleetSample object is what an Exporter collects about a single device.
+type fleetSample struct {
+	dev    *Device
+	fwd    *FirmwareDevice
+	health *HealthCheck
+	stats  *DeviceStatistics
+	hist   *FirmwareHistory
+}
+
+// Refresh collects the fleet metrics from the GW and FMS. Health checks and
+// statistics are only requested from connected devices, and a device failing
+// either is counted as an error without failing the refresh.
+func (ex *Exporter) Refresh() error {
+	start := time.Now()
+	samples, failed, err := ex.collect()
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.errors == nil {
+		ex.errors = make(map[string]int)
+	}
+	for source, n := range failed {
+		ex.errors[source] += n
+	}
+	ex.refreshes++
+	ex.duration = time.Since(start)
+	ex.up = err == nil
+	if err != nil {
+		return err
+	}
+	ex.fleet = fleetMetrics(samples, time.Now())
+	ex.lastSuccess = time.Now()
+	return nil
+}
+
+func (ex *Exporter) collect() ([]*fleetSample, map[string]int, error) {
+	failed := make(map[string]int)
+	if ex.Reauthenticate != nil && !ex.UC.OAuth2.Valid(time.Now(), 0) {
+		if err := ex.Reauthenticate(); err != nil {
+			failed["login"]++
+			return nil, failed, err
+		}
+	}
+	devs, err := ex.UC.ListDevices()
+	if err != nil {
+		failed["devices"]++
+		return nil, failed, err
+	}
+	fwds, err := ex.UC.GetAllFirmwareDevices()
+	if err != nil {
+		failed["firmware"]++
+		return nil, failed, err
+	}
+	byID := make(map[string]*FirmwareDevice)
+	for i := 0; i < len(fwds.Entry); i++ {
+		byID[fwds.Entry[i].SerialNumber] = fwds.Entry[i]
+	}
+	histories := make(map[string]*FirmwareHistory)
+	samples := make([]*fleetSample, len(devs.Entry))
+	for i := 0; i < len(devs.Entry); i++ {
+		dev := devs.Entry[i]
+		if _, ok := histories[dev.DeviceType]; !ok {
+			histories[dev.DeviceType], err = ex.UC.GetFirmwareHistory(dev.DeviceType)
+			if err != nil {
+				failed["history"]++
+			}
+		}
+		samples[i] = &fleetSample{
+			dev:  dev,
+			fwd:  byID[dev.SerialNumber],
+			hist: histories[dev.DeviceType],
+		}
+	}
+	sort.Slice(samples, func(a, b int) bool {
+		return samples[a].dev.SerialNumber < samples[b].dev.SerialNumber
+	})
+
+	workers := ex.Workers
+	if workers < 1 {
+		workers = 8
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan *fleetSample)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range queue {
+				hc, herr := ex.UC.GetDeviceHealthCheck(s.dev.SerialNumber)
+				st, serr := ex.UC.GetDeviceStatistics(s.dev.SerialNumber)
+				mu.Lock()
+				if herr == nil {
+					s.health = hc
+				} else {
+					failed["healthcheck"]++
+				}
+				if serr == nil {
+					s.stats = st
+				} else {
+					failed["statistics"]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, s := range samples {
+		if s.fwd != nil && s.fwd.IsConnected() {
+			queue <- s
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return samples, failed, nil
+}
+
+// ServeHTTP writes the metrics of the last successful refresh.
+func (ex *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ex.mu.Lock()
+	var buf bytes.Buffer
+	buf.Write(ex.fleet)
+	pw := &promWriter{w: &buf}
+	pw.family("tipwifi_up", "gauge", "Whether the last refresh from the GW and FMS succeeded.")
+	pw.sample(boolValue(ex.up))
+	pw.family("tipwifi_refreshes_total", "counter", "Refreshes from the GW and FMS attempted.")
+	pw.sample(float64(ex.refreshes))
+	pw.family("tipwifi_refresh_duration_seconds", "gauge", "Duration of the last refresh from the GW and FMS.")
+	pw.sample(ex.duration.Seconds())
+	pw.family("tipwifi_refresh_errors_total", "counter", "Failed requests to the GW and FMS by source.")
+	sources := make([]string, 0, len(ex.errors))
+	for source := range ex.errors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		pw.sample(float64(ex.errors[source]), "source", source)
+	}
+	if !ex.lastSuccess.IsZero() {
+		pw.family("tipwifi_last_refresh_timestamp_seconds", "gauge", "Unix time of the last successful refresh.")
+		pw.sample(float64(ex.lastSuccess.Unix()))
+	}
+	ex.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// fleetMetrics renders the device metrics of a refresh.
+func fleetMetrics(samples []*fleetSample, now time.Time) []byte {
+	var buf bytes.Buffer
+	pw := &promWriter{w: &buf}
+
+	pw.family("tipwifi_device_connected", "gauge", "Whether the device is connected according to the FMS.")
+	for _, s := range samples {
+		pw.sample(boolValue(s.fwd != nil && s.fwd.IsConnected()), "serial_number", s.dev.SerialNumber, "device_type", s.dev.DeviceType, "venue", s.dev.Venue)
+	}
+	pw.family("tipwifi_device_firmware_info", "gauge", "Firmware revision running on the device, always 1.")
+	for _, s := range samples {
+		pw.sample(1, "serial_number", s.dev.SerialNumber, "device_type", s.dev.DeviceType, "revision", s.revision())
+	}
+	pw.family("tipwifi_device_config_change_age_seconds", "gauge", "Seconds since the configuration of the device last changed on the GW.")
+	for _, s := range samples {
+		if s.dev.LastConfigurationChange > 0 {
+			pw.sample(float64(now.Unix()-int64(s.dev.LastConfigurationChange)), "serial_number", s.dev.SerialNumber)
+		}
+	}
+	pw.family("tipwifi_device_config_download_age_seconds", "gauge", "Seconds since the device last downloaded its configuration.")
+	for _, s := range samples {
+		if s.dev.LastConfigurationDownload > 0 {
+			pw.sample(float64(now.Unix()-int64(s.dev.LastConfigurationDownload)), "serial_number", s.dev.SerialNumber)
+		}
+	}
+	pw.family("tipwifi_device_health_sanity", "gauge", "Sanity of the most recent health check, 0-100 where 100 is healthy.")
+	for _, s := range samples {
+		if s.health != nil {
+			pw.sample(float64(s.health.Sanity), "serial_number", s.dev.SerialNumber)
+		}
+	}
+	pw.family("tipwifi_device_health_score", "gauge", "Computed health score of the device, 0-100 where 100 is healthy. Reboots are not counted.")
+	for _, s := range samples {
+		in := &HealthInputs{
+			Device:      s.dev,
+			Status:      s.fwd,
+			HealthCheck: s.health,
+			Statistics:  s.stats,
+			Reboots:     -1,
+			History:     s.hist,
+		}
+		pw.sample(float64(ScoreHealth(in, DefaultConfigLag, now).Score), "serial_number", s.dev.SerialNumber)
+	}
+	pw.family("tipwifi_device_uptime_seconds", "gauge", "Seconds since the device booted, from its latest statistics.")
+	for _, s := range samples {
+		if s.stats != nil {
+			pw.sample(float64(s.stats.Unit.Uptime), "serial_number", s.dev.SerialNumber)
+		}
+	}
+	pw.family("tipwifi_radio_clients", "gauge", "Clients associated to each radio of the device.")
+	for _, s := range samples {
+		if s.stats == nil {
+			continue
+		}
+		for i, n := range s.stats.ClientsPerRadio() {
+			pw.sample(float64(n), "serial_number", s.dev.SerialNumber, "radio", strconv.Itoa(i), "phy", s.stats.Radios[i].Phy)
+		}
+	}
+	pw.family("tipwifi_radio_channel", "gauge", "Channel each radio of the device is operating on.")
+	for _, s := range samples {
+		if s.stats == nil {
+			continue
+		}
+		for i, r := range s.stats.Radios {
+			pw.sample(float64(r.Channel), "serial_number", s.dev.SerialNumber, "radio", strconv.Itoa(i), "phy", r.Phy)
+		}
+	}
+
+	counts := func(name, help, label string, key func(s *fleetSample) string) {
+		n := make(map[string]int)
+		for _, s := range samples {
+			n[key(s)]++
+		}
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pw.family(name, "gauge", help)
+		for _, k := range keys {
+			pw.sample(float64(n[k]), label, k)
+		}
+	}
+	counts("tipwifi_devices_by_type", "Devices registered on the GW by device type.", "device_type", func(s *fleetSample) string {
+		return s.dev.DeviceType
+	})
+	counts("tipwifi_devices_by_firmware", "Devices registered on the GW by firmware revision.", "revision", (*fleetSample).revision)
+	counts("tipwifi_devices_by_venue", "Devices registered on the GW by venue.", "venue", func(s *fleetSample) string {
+		return s.dev.Venue
+	})
+	return buf.Bytes()
+}
+
+// revision prefers the FMS revision, which follows upgrades sooner than the GW.
+func (s *fleetSample) revision() string {
+	if s.fwd != nil && s.fwd.Revision != "" {
+		return s.fwd.Revision
+	}
+	return s.dev.Firmware
+}
+
+// The promWriter object writes metric families in the Prometheus text
+// exposition format. Samples belong to the family most recently started.
+type promWriter struct {
+	w    *bytes.Buffer
+	name string
+}
+
+func (pw *promWriter) family(name, typ, help string) {
+	pw.name = name
+	fmt.Fprintf(pw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a value of the current family with the labels supplied as
+// name, value pairs.
+func (pw *promWriter) sample(value float64, labels ...string) {
+	pw.w.WriteString(pw.name)
+	if len(labels) > 1 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], promEscaper.Replace(labels[i+1])))
+		}
+		fmt.Fprintf(pw.w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(pw.w, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
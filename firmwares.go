@@ -1,7 +1,9 @@
 package tipWifi
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 )
 
 // The Firmwares object contains a list of the Firmware object.
@@ -18,62 +20,80 @@ func (fws *Firmwares) GenerateList() (list []string) {
 	return list
 }
 
-// The DeviceTypes list is a static record of the applicable device types
-// as returned from the uc.FMS 'firmwares?deviceSet=true' endpoint.
-var DeviceTypes = []string{
-	"cig_wf160d",
-	"cig_wf188",
-	"cig_wf194c",
-	"edgecore_eap101",
-	"edgecore_eap102",
-	"edgecore_ecs4100-12ph",
-	"edgecore_ecw5211",
-	"edgecore_ecw5410",
-	"edgecore_oap100",
-	"edgecore_spw2ac1200",
-	"edgecore_ssw2ac2600",
-	"hfcl_ion4.yml",
-	"indio_um-305ac",
-	"linksys_e8450-ubi",
-	"linksys_ea6350",
-	"linksys_ea8300",
-	"mikrotik_nand",
-	"mikrotik_nand-large",
-	"tplink_cpe210_v3",
-	"tplink_cpe510_v3",
-	"tplink_eap225_outdoor_v1",
-	"tplink_ec420",
-	"tplink_ex227",
-	"tplink_ex228",
-	"tplink_ex447",
-	"wallys_dr40x9",
+// Report returns a Report with one row per Firmware entry.
+func (fws *Firmwares) Report() *Report {
+	r := NewReport("Firmwares", firmwareColumns...)
+	for i := 0; i < len(fws.Entry); i++ {
+		fws.Entry[i].addTo(r)
+	}
+	return r
+}
+
+// Report returns the Firmware as a single row Report.
+func (fw *Firmware) Report() *Report {
+	r := NewReport(fw.DeviceType, firmwareColumns...)
+	fw.addTo(r)
+	return r
+}
+
+var firmwareColumns = []string{"id", "release", "revision", "imageDate", "created", "size", "uri"}
+
+func (fw *Firmware) addTo(r *Report) {
+	r.Add(fw.ID, fw.Release, fw.Revision, fw.ImageDate, fw.Created, fw.Size, fw.URI)
+}
+
+// FindByRevision returns the Firmware entry matching the supplied revision, or nil.
+func (fws *Firmwares) FindByRevision(rev string) *Firmware {
+	for i := 0; i < len(fws.Entry); i++ {
+		if fws.Entry[i].Revision == rev {
+			return fws.Entry[i]
+		}
+	}
+	return nil
+}
+
+// FindByURI returns the Firmware entry matching the supplied download URI, or nil.
+func (fws *Firmwares) FindByURI(uri string) *Firmware {
+	for i := 0; i < len(fws.Entry); i++ {
+		if fws.Entry[i].URI == uri {
+			return fws.Entry[i]
+		}
+	}
+	return nil
+}
+
+// SortByImageDate orders the Firmware entries from newest to oldest image.
+func (fws *Firmwares) SortByImageDate() {
+	sort.SliceStable(fws.Entry, func(a, b int) bool {
+		return fws.Entry[a].ImageDate > fws.Entry[b].ImageDate
+	})
 }
 
 // The Firmware object contains version control information including the download URI.
 type Firmware struct {
-	Created       int           `json:"created"`
-	Description   string        `json:"description"`
-	DeviceType    string        `json:"deviceType"`
-	Digest        string        `json:"digest"`
-	DownloadCount int           `json:"downloadCount"`
-	FirmwareHash  string        `json:"firmwareHash"`
-	ID            string        `json:"id"`
-	Image         string        `json:"image"`
-	ImageDate     int           `json:"imageDate"`
-	Latest        bool          `json:"latest"`
-	Location      string        `json:"location"`
-	Notes         []interface{} `json:"notes"`
-	Owner         string        `json:"owner"`
-	Release       string        `json:"release"`
-	Revision      string        `json:"revision"`
-	Size          int           `json:"size"`
-	Uploader      string        `json:"uploader"`
-	URI           string        `json:"uri"`
+	Created       int     `json:"created"`
+	Description   string  `json:"description"`
+	DeviceType    string  `json:"deviceType"`
+	Digest        string  `json:"digest"`
+	DownloadCount int     `json:"downloadCount"`
+	FirmwareHash  string  `json:"firmwareHash"`
+	ID            string  `json:"id"`
+	Image         string  `json:"image"`
+	ImageDate     int     `json:"imageDate"`
+	Latest        bool    `json:"latest"`
+	Location      string  `json:"location"`
+	Notes         []*Note `json:"notes"` // same created/createdBy/note model as the GW Device notes
+	Owner         string  `json:"owner"`
+	Release       string  `json:"release"`
+	Revision      string  `json:"revision"`
+	Size          int     `json:"size"`
+	Uploader      string  `json:"uploader"`
+	URI           string  `json:"uri"`
 }
 
 // GenerateDescription returns a string of concatenated values describing the Firmware object.
 func (fw *Firmware) GenerateDescription() string {
-	desc := fmt.Sprintf("ID: %s", fw.ID)
+	desc := fmt.Sprintf("ID: %s, ", fw.ID)
 	desc += fmt.Sprintf("Release: %s, ", fw.Release)
 	desc += fmt.Sprintf("Revision: %s, ", fw.Revision)
 	desc += fmt.Sprintf("Image Date: %d, Created: %d, ", fw.ImageDate, fw.Created)
@@ -105,6 +125,15 @@ type Notes struct {
 	Notes        []*Note `json:"notes"`
 }
 
+// The Configure object is used to marshal the configure data. The Configuration
+// is kept as raw JSON so that no part of a pushed configuration is dropped.
+type Configure struct {
+	SerialNumber  string          `json:"serialNumber"`
+	UUID          int             `json:"UUID"`
+	When          int             `json:"when"` // unix time to apply the configuration, 0 for now
+	Configuration json.RawMessage `json:"configuration"`
+}
+
 // The FirmwareDevice object represents the uc.FMS "Device" object and is used
 // for checking status and firmware upgrade activities.
 type FirmwareDevice struct {
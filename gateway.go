@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"net/http"
 	"strings"
 	"time"
 )
@@ -50,6 +52,9 @@ type UCentral struct {
 	FMS    string
 	Auth   *Auth
 	OAuth2 *OAuth2
+	Mirror *Mirror       // when set, upgrades use the local copy of an image if the Mirror has one
+	Policy *PolicyEngine // local upgrade rules applied on top of each device's FwUpdatePolicy
+	DryRun bool          // print the requests that would change devices instead of sending them
 }
 
 // The Endpoints object contains a list of the Endpoint object.
@@ -113,7 +118,8 @@ func (uc *UCentral) Logout() error {
 	if Debug {
 		fmt.Printf("|+| %s |+|\n", resp.Status)
 	}
-	if resp.Status != "204 OK" {
+	// the SEC answers "204 No Content" once the token is deleted
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
 		return errors.New(resp.Status)
 	}
 	return nil
@@ -322,6 +328,7 @@ func (uc *UCentral) GetLatestFirmwareByDevice(dev string) (*Firmware, error) {
 
 // UpgradeDeviceToLatest takes a FirmwareDevice as input wrapper around the
 // UpgradeDeviceFirmware function to control the input variables.
+// The upgrade is refused or deferred with a PolicyError when policy disallows it.
 func (uc *UCentral) UpgradeDeviceToLatest(dev *FirmwareDevice) error {
 	fw, err := uc.GetLatestFirmwareByDevice(dev.DeviceType)
 	if err != nil {
@@ -331,13 +338,43 @@ func (uc *UCentral) UpgradeDeviceToLatest(dev *FirmwareDevice) error {
 	if dev.Revision == fw.Revision {
 		return errors.New("Latest Revision same as Current Version!")
 	}
-	fmt.Println(dev.SerialNumber, fw.URI)
+	err = uc.CheckUpgradePolicy(dev.SerialNumber, fw)
+	if err != nil {
+		return err
+	}
+	log.Println(dev.SerialNumber, ":Upgrading to", fw.URI)
 	return uc.UpgradeDeviceFirmware(dev.SerialNumber, fw.URI)
 }
 
+// UpgradeDeviceToURI upgrades a FirmwareDevice to the image at the supplied URI.
+// The Firmware is looked up by URI in the FMS list for the device type so that
+// upgrade policy sees its Release and Revision, and the upgrade is refused or
+// deferred with a PolicyError when policy disallows it.
+func (uc *UCentral) UpgradeDeviceToURI(dev *FirmwareDevice, uri string) error {
+	fw := &Firmware{DeviceType: dev.DeviceType, URI: uri}
+	fws, err := uc.GetFirmwareListByDevice(dev.DeviceType)
+	if err == nil {
+		if match := fws.FindByURI(uri); match != nil {
+			fw = match
+		}
+	}
+	if fw.Revision != "" && dev.Revision == fw.Revision {
+		return errors.New("Target Revision same as Current Version!")
+	}
+	err = uc.CheckUpgradePolicy(dev.SerialNumber, fw)
+	if err != nil {
+		return err
+	}
+	return uc.UpgradeDeviceFirmware(dev.SerialNumber, uri)
+}
+
 // UpgradeDeviceFirmware takes a SerialNumber and URI (link to get new fw) as input
 // and applies the upgrade to the device, returning any error.
+// If a Mirror is configured and holds the image, the Mirror URI is used instead.
 func (uc *UCentral) UpgradeDeviceFirmware(sn, uri string) error {
+	if uc.Mirror != nil {
+		uri = uc.Mirror.RewriteURI(uri)
+	}
 	upg := &Upgrade{
 		SerialNumber: sn,
 		URI:          uri,
@@ -346,20 +383,7 @@ func (uc *UCentral) UpgradeDeviceFirmware(sn, uri string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := PostRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s/upgrade", sn), jsonData)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	if Debug {
-		fmt.Printf("|+| %s |+|\n", resp.Status)
-	}
-
-	return json.Unmarshal(body, &uc.OAuth2)
+	return uc.mutate("POST", fmt.Sprintf("device/%s/upgrade", sn), jsonData)
 }
 
 // RebootDevice takes a SerialNumber as input and reboots the device
@@ -371,18 +395,7 @@ func (uc *UCentral) RebootDevice(sn string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := PostRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s/reboot", sn), jsonData)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if Debug {
-		fmt.Printf("|+| %s |+|\n", resp.Status)
-	}
-	if resp.Status != "200 OK" {
-		return errors.New(resp.Status)
-	}
-	return nil
+	return uc.mutate("POST", fmt.Sprintf("device/%s/reboot", sn), jsonData)
 }
 
 // Factory reset takes a SerialNumber and bool as input and factory resets the device.
@@ -397,18 +410,7 @@ func (uc *UCentral) FactoryResetDevice(sn string, keepRedirector bool) error {
 	if err != nil {
 		return err
 	}
-	resp, err := PostRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s/factory", sn), jsonData)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if Debug {
-		fmt.Printf("|+| %s |+|\n", resp.Status)
-	}
-	if resp.Status != "200 OK" {
-		return errors.New(resp.Status)
-	}
-	return nil
+	return uc.mutate("POST", fmt.Sprintf("device/%s/factory", sn), jsonData)
 }
 
 // AddNoteToDevice access a SerialNumber and slice of strings as input, and applied
@@ -430,7 +432,121 @@ func (uc *UCentral) AddNotesToDevice(sn string, notes []string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := PutRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s", sn), jsonData)
+	return uc.mutate("PUT", fmt.Sprintf("device/%s", sn), jsonData)
+}
+
+// GetDeviceHealthCheck returns the most recent HealthCheck recorded by the GW for
+// the supplied Serial Number. The Sanity value ranges from 0-100 where 100 is healthy.
+func (uc *UCentral) GetDeviceHealthCheck(sn string) (*HealthCheck, error) {
+	resp, err := GetRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s/healthchecks?newest=true&limit=1", sn))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+
+	hcs := &HealthChecks{}
+	err = json.Unmarshal(body, &hcs)
+	if err != nil {
+		return nil, err
+	}
+	if len(hcs.Entry) < 1 {
+		return nil, errors.New("No Health Check Recorded")
+	}
+	return hcs.Entry[0], nil
+}
+
+// GetDeviceStatistics returns the most recent DeviceStatistics recorded by the GW
+// for the supplied Serial Number.
+func (uc *UCentral) GetDeviceStatistics(sn string) (*DeviceStatistics, error) {
+	resp, err := GetRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s/statistics?lastOnly=true", sn))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	ds := &DeviceStatistics{}
+	err = json.Unmarshal(body, &ds)
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// GetDeviceConfiguration returns the raw JSON Configuration of the device from the GW.
+// Unlike GetDevice, every element of the configuration is preserved as returned.
+func (uc *UCentral) GetDeviceConfiguration(sn string) (json.RawMessage, error) {
+	resp, err := GetRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s", sn))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+
+	raw := &struct {
+		Configuration json.RawMessage `json:"configuration"`
+	}{}
+	err = json.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw.Configuration) < 1 {
+		return nil, errors.New("Device Has No Configuration")
+	}
+	return raw.Configuration, nil
+}
+
+// ConfigureDevice takes a SerialNumber and a raw JSON configuration as input and
+// pushes the configuration to the device.
+func (uc *UCentral) ConfigureDevice(sn string, cfg json.RawMessage) error {
+	c := &Configure{
+		SerialNumber:  sn,
+		UUID:          1, // the GW assigns the UUID of the new configuration
+		Configuration: cfg,
+	}
+	jsonData, err := json.Marshal(&c)
+	if err != nil {
+		return err
+	}
+	return uc.mutate("POST", fmt.Sprintf("device/%s/configure", sn), jsonData)
+}
+
+// mutate sends a request that changes the state of a device to the uc.GW. With
+// DryRun set the request is printed instead and nothing is sent.
+func (uc *UCentral) mutate(method, uri string, data []byte) error {
+	if uc.DryRun {
+		log.Printf("DRY RUN: %s https://%s/api/v1/%s %s\n", method, uc.GW, uri, data)
+		return nil
+	}
+	var resp *http.Response
+	var err error
+	if method == "PUT" {
+		resp, err = PutRequest(uc.OAuth2, uc.GW, uri, data)
+	} else {
+		resp, err = PostRequest(uc.OAuth2, uc.GW, uri, data)
+	}
 	if err != nil {
 		return err
 	}
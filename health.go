@@ -0,0 +1,400 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults of the HealthOptions.
+const (
+	DefaultHealthWindow = 24 * time.Hour
+	DefaultConfigLag    = time.Hour
+)
+
+// Penalties subtracted from a perfect HealthScore of 100.
+const (
+	penaltyDisconnected  = 50
+	penaltyUnknownStatus = 10 // the FMS has no connection status for the device
+	penaltyNoHealthCheck = 10
+	penaltyRecentBoot    = 10 // the device booted within the last hour
+	penaltyPerReboot     = 10 // per reboot within the window, at most maxRebootPenalty
+	maxRebootPenalty     = 30
+	penaltyPerRelease    = 5 // per release behind the latest, at most maxFirmwarePenalty
+	maxFirmwarePenalty   = 20
+	penaltyUnknownFw     = 10 // the running revision is not in the FMS registry
+	penaltyConfigLag     = 15
+)
+
+// The StatisticsRecord object is a DeviceStatistics as recorded by the GW at a point in time.
+type StatisticsRecord struct {
+	Recorded int               `json:"recorded"`
+	UUID     int               `json:"UUID"`
+	Data     *DeviceStatistics `json:"data"`
+}
+
+// GetDeviceStatisticsHistory returns the DeviceStatistics recorded by the GW
+// for the supplied Serial Number between the two times, oldest first.
+func (uc *UCentral) GetDeviceStatisticsHistory(sn string, from, to time.Time) ([]*StatisticsRecord, error) {
+	resp, err := GetRequest(uc.OAuth2, uc.GW, fmt.Sprintf("device/%s/statistics?startDate=%d&endDate=%d", sn, from.Unix(), to.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	hist := &struct {
+		Entry []*StatisticsRecord `json:"data"`
+	}{}
+	err = json.Unmarshal(body, &hist)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hist.Entry, func(a, b int) bool {
+		return hist.Entry[a].Recorded < hist.Entry[b].Recorded
+	})
+	return hist.Entry, nil
+}
+
+// CountReboots returns the number of times the uptime of consecutive records
+// went down, which happens only when the device restarted in between.
+func CountReboots(records []*StatisticsRecord) (n int) {
+	last := -1
+	for _, rec := range records {
+		if rec.Data == nil {
+			continue
+		}
+		if last >= 0 && rec.Data.Unit.Uptime < last {
+			n++
+		}
+		last = rec.Data.Unit.Uptime
+	}
+	return n
+}
+
+// ConfigLag returns how long a configuration change has been waiting to be
+// downloaded by the device, or zero if it downloaded the latest change.
+func ConfigLag(dev *Device, now time.Time) time.Duration {
+	if dev.LastConfigurationChange <= dev.LastConfigurationDownload {
+		return 0
+	}
+	return now.Sub(time.Unix(int64(dev.LastConfigurationChange), 0))
+}
+
+// The HealthOptions object controls how devices are scored.
+type HealthOptions struct {
+	Window    time.Duration // period over which reboots are counted, def: DefaultHealthWindow
+	ConfigLag time.Duration // a pending configuration older than this is penalised, def: DefaultConfigLag
+	Bulk      *BulkOptions  // how ScoreFleet queries the devices
+}
+
+func (opts *HealthOptions) window() time.Duration {
+	if opts == nil || opts.Window <= 0 {
+		return DefaultHealthWindow
+	}
+	return opts.Window
+}
+
+func (opts *HealthOptions) configLag() time.Duration {
+	if opts == nil || opts.ConfigLag <= 0 {
+		return DefaultConfigLag
+	}
+	return opts.ConfigLag
+}
+
+// The HealthInputs object gathers what a HealthScore is computed from. Any of
+// the pointers may be nil when the information could not be retrieved.
+type HealthInputs struct {
+	Device      *Device
+	Status      *FirmwareDevice
+	HealthCheck *HealthCheck
+	Statistics  *DeviceStatistics
+	Reboots     int // within the window, -1 if unknown
+	History     *FirmwareHistory
+}
+
+// The HealthScore object is the computed health of a device, from 0 to 100
+// where 100 is healthy, with one reason for every penalty applied. Fields that
+// could not be determined hold -1.
+type HealthScore struct {
+	SerialNumber   string
+	DeviceType     string
+	Score          int
+	Connected      bool
+	Sanity         int
+	BootUptime     time.Duration // since the device booted, from its statistics, not since it connected to the GW
+	Reboots        int
+	Revision       string
+	ReleasesBehind int
+	ConfigLag      time.Duration
+	Reasons        []string
+}
+
+// ScoreHealth computes the HealthScore of a device. A ConfigLag shorter than
+// the supplied threshold is reported but not penalised.
+func ScoreHealth(in *HealthInputs, configLag time.Duration, now time.Time) *HealthScore {
+	hs := &HealthScore{
+		SerialNumber:   in.Device.SerialNumber,
+		DeviceType:     in.Device.DeviceType,
+		Score:          100,
+		Sanity:         -1,
+		BootUptime:     -1,
+		Reboots:        in.Reboots,
+		Revision:       in.Device.Firmware,
+		ReleasesBehind: -1,
+		ConfigLag:      ConfigLag(in.Device, now),
+	}
+	penalise := func(points int, format string, a ...interface{}) {
+		hs.Score -= points
+		hs.Reasons = append(hs.Reasons, fmt.Sprintf(format, a...))
+	}
+
+	if in.Status != nil {
+		hs.Connected = in.Status.IsConnected()
+		if in.Status.Revision != "" {
+			hs.Revision = in.Status.Revision
+		}
+		if !hs.Connected {
+			penalise(penaltyDisconnected, "Disconnected")
+		}
+	} else {
+		penalise(penaltyUnknownStatus, "Connection status unknown")
+	}
+	if in.HealthCheck != nil {
+		hs.Sanity = in.HealthCheck.Sanity
+		if hs.Sanity < 100 {
+			penalise((100-hs.Sanity)/2, "Health check sanity %d%%", hs.Sanity)
+		}
+	} else if hs.Connected {
+		penalise(penaltyNoHealthCheck, "No health check recorded")
+	}
+	if in.Statistics != nil {
+		hs.BootUptime = time.Duration(in.Statistics.Unit.Uptime) * time.Second
+		if hs.BootUptime < time.Hour {
+			penalise(penaltyRecentBoot, "Booted %s ago", hs.BootUptime)
+		}
+	}
+	if hs.Reboots > 0 {
+		points := hs.Reboots * penaltyPerReboot
+		if points > maxRebootPenalty {
+			points = maxRebootPenalty
+		}
+		penalise(points, "Rebooted %d time(s) recently", hs.Reboots)
+	}
+	if in.History != nil && len(in.History.Entry) > 0 {
+		behind := in.History.Since(hs.Revision)
+		if len(behind) == len(in.History.Entry) {
+			penalise(penaltyUnknownFw, "Revision %s not in FMS registry", hs.Revision)
+		} else {
+			hs.ReleasesBehind = len(behind)
+			if hs.ReleasesBehind > 0 {
+				points := hs.ReleasesBehind * penaltyPerRelease
+				if points > maxFirmwarePenalty {
+					points = maxFirmwarePenalty
+				}
+				penalise(points, "%d firmware release(s) behind %s", hs.ReleasesBehind, in.History.Entry[0].Revision)
+			}
+		}
+	}
+	if hs.ConfigLag > configLag {
+		penalise(penaltyConfigLag, "Configuration change not downloaded for %s", hs.ConfigLag.Round(time.Minute))
+	}
+	if hs.Score < 0 {
+		hs.Score = 0
+	}
+	return hs
+}
+
+// GenerateDescription returns a string of concatenated values describing the HealthScore object.
+func (hs *HealthScore) GenerateDescription() string {
+	desc := fmt.Sprintf("%s: Score: %d, ", hs.SerialNumber, hs.Score)
+	for _, r := range hs.Reasons {
+		desc += r + ", "
+	}
+	return desc
+}
+
+// ScoreDevice gathers the HealthInputs of a single device and scores it.
+func (uc *UCentral) ScoreDevice(sn string, opts *HealthOptions) (*HealthScore, error) {
+	dev, err := uc.GetDevice(sn)
+	if err != nil {
+		return nil, err
+	}
+	if dev.SerialNumber == "" {
+		return nil, errors.New("SN Not Found")
+	}
+	fwd, _ := uc.GetFirmwareDevice(sn)
+	hist, _ := uc.GetFirmwareHistory(dev.DeviceType)
+	now := time.Now()
+	in := uc.healthInputs(dev, fwd, hist, opts, now)
+	return ScoreHealth(in, opts.configLag(), now), nil
+}
+
+// healthInputs fetches the health check and statistics of a connected device.
+func (uc *UCentral) healthInputs(dev *Device, fwd *FirmwareDevice, hist *FirmwareHistory, opts *HealthOptions, now time.Time) *HealthInputs {
+	in := &HealthInputs{
+		Device:  dev,
+		Status:  fwd,
+		History: hist,
+		Reboots: -1,
+	}
+	if fwd == nil || !fwd.IsConnected() {
+		return in
+	}
+	in.HealthCheck, _ = uc.GetDeviceHealthCheck(dev.SerialNumber)
+	in.Statistics, _ = uc.GetDeviceStatistics(dev.SerialNumber)
+	if recs, err := uc.GetDeviceStatisticsHistory(dev.SerialNumber, now.Add(-opts.window()), now); err == nil {
+		in.Reboots = CountReboots(recs)
+	}
+	return in
+}
+
+// The FleetHealth object holds the HealthScore of every device, worst first.
+type FleetHealth struct {
+	Taken  time.Time
+	Scores []*HealthScore
+	Failed *BulkResults // devices that could not be scored, nil if none
+}
+
+// ScoreFleet scores the supplied devices, or every device registered on the
+// GW when none are supplied. Supplied devices the GW does not know are
+// reported as failed.
+func (uc *UCentral) ScoreFleet(opts *HealthOptions, sns ...string) (*FleetHealth, error) {
+	devs, err := uc.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	fwds, err := uc.GetAllFirmwareDevices()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*FirmwareDevice)
+	for i := 0; i < len(fwds.Entry); i++ {
+		byID[fwds.Entry[i].SerialNumber] = fwds.Entry[i]
+	}
+	wanted := make(map[string]bool)
+	for _, sn := range sns {
+		wanted[sn] = true
+	}
+	devByID := make(map[string]*Device)
+	histories := make(map[string]*FirmwareHistory)
+	requested := sns
+	sns = nil
+	for i := 0; i < len(devs.Entry); i++ {
+		dev := devs.Entry[i]
+		if len(wanted) > 0 && !wanted[dev.SerialNumber] {
+			continue
+		}
+		devByID[dev.SerialNumber] = dev
+		sns = append(sns, dev.SerialNumber)
+		if _, ok := histories[dev.DeviceType]; !ok {
+			histories[dev.DeviceType], _ = uc.GetFirmwareHistory(dev.DeviceType)
+		}
+	}
+
+	var unknown []string
+	for _, sn := range requested {
+		if devByID[sn] == nil {
+			unknown = append(unknown, sn)
+		}
+	}
+
+	fh := &FleetHealth{Taken: time.Now()}
+	scores := make(map[string]*HealthScore)
+	var mu sync.Mutex
+	var bulk *BulkOptions
+	if opts != nil {
+		bulk = opts.Bulk
+	}
+	results := RunBulk("health", sns, bulk, func(sn string) (string, error) {
+		dev := devByID[sn]
+		in := uc.healthInputs(dev, byID[sn], histories[dev.DeviceType], opts, fh.Taken)
+		hs := ScoreHealth(in, opts.configLag(), fh.Taken)
+		mu.Lock()
+		scores[sn] = hs
+		mu.Unlock()
+		return fmt.Sprintf("score %d", hs.Score), nil
+	})
+	// a device that timed out may still complete in the background
+	mu.Lock()
+	for _, r := range results.Entry {
+		if r.Status == BulkOK {
+			fh.Scores = append(fh.Scores, scores[r.SerialNumber])
+		}
+	}
+	mu.Unlock()
+	for _, sn := range unknown {
+		results.Entry = append(results.Entry, &BulkResult{SerialNumber: sn, Status: BulkFailed, Error: "SN Not Found"})
+	}
+	if len(results.Failed()) > 0 {
+		fh.Failed = results
+	}
+	sort.SliceStable(fh.Scores, func(a, b int) bool {
+		if fh.Scores[a].Score != fh.Scores[b].Score {
+			return fh.Scores[a].Score < fh.Scores[b].Score
+		}
+		return fh.Scores[a].SerialNumber < fh.Scores[b].SerialNumber
+	})
+	return fh, nil
+}
+
+// Worst returns up to n of the lowest scores, or every score if n < 1.
+func (fh *FleetHealth) Worst(n int) []*HealthScore {
+	if n < 1 || n > len(fh.Scores) {
+		return fh.Scores
+	}
+	return fh.Scores[:n]
+}
+
+// Average returns the mean score of the fleet.
+func (fh *FleetHealth) Average() float64 {
+	if len(fh.Scores) < 1 {
+		return 0
+	}
+	total := 0
+	for _, hs := range fh.Scores {
+		total += hs.Score
+	}
+	return float64(total) / float64(len(fh.Scores))
+}
+
+// Report returns a Report of the n worst devices, or of every device if n < 1.
+func (fh *FleetHealth) Report(n int) *Report {
+	title := fmt.Sprintf("Fleet Health: %d device(s), average score %.1f", len(fh.Scores), fh.Average())
+	return healthReport(title, fh.Worst(n))
+}
+
+// Report returns the HealthScore as a single row Report.
+func (hs *HealthScore) Report() *Report {
+	return healthReport(hs.SerialNumber, []*HealthScore{hs})
+}
+
+func healthReport(title string, list []*HealthScore) *Report {
+	r := NewReport(title, "serialNumber", "deviceType", "score", "status", "sanity", "bootUptime", "reboots", "revision", "releasesBehind", "configLag", "reasons")
+	for _, hs := range list {
+		uptime := ""
+		if hs.BootUptime >= 0 {
+			uptime = hs.BootUptime.Round(time.Minute).String()
+		}
+		lag := ""
+		if hs.ConfigLag > 0 {
+			lag = hs.ConfigLag.Round(time.Minute).String()
+		}
+		r.Add(hs.SerialNumber, hs.DeviceType, hs.Score, upDown(hs.Connected), hs.Sanity, uptime, hs.Reboots, hs.Revision, hs.ReleasesBehind, lag, hs.Reasons)
+	}
+	return r
+}
@@ -0,0 +1,187 @@
+package tipWifi
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The Mirror object keeps local copies of firmware images in Dir and serves them
+// over HTTP at BaseURL, so that devices can upgrade from an internal server
+// instead of the public image URIs registered in the FMS.
+type Mirror struct {
+	Dir     string // local directory holding the images
+	BaseURL string // URL at which devices reach the served Dir, ex: http://10.0.0.5:8080
+	Addr    string // listen address for Serve, ex: :8080
+}
+
+// LocalPath returns the path an image download URI is stored at within the Mirror.
+func (m *Mirror) LocalPath(uri string) string {
+	return filepath.Join(m.Dir, imageName(uri))
+}
+
+// Has returns whether the image behind the supplied URI is present in the Mirror.
+func (m *Mirror) Has(uri string) bool {
+	fi, err := os.Stat(m.LocalPath(uri))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// RewriteURI returns the Mirror URL for an image that is present locally,
+// and the original URI otherwise.
+func (m *Mirror) RewriteURI(uri string) string {
+	if m.BaseURL == "" || !m.Has(uri) {
+		return uri
+	}
+	return strings.TrimSuffix(m.BaseURL, "/") + "/" + url.PathEscape(imageName(uri))
+}
+
+// Sync downloads every image registered for the Device Type that is not yet
+// present in the Mirror, verifying each download against the FMS record.
+// The names of the images fetched are returned.
+func (m *Mirror) Sync(uc *UCentral, devType string) (list []string, err error) {
+	fws, err := uc.GetFirmwareListByDevice(devType)
+	if err != nil {
+		return list, err
+	}
+	err = os.MkdirAll(m.Dir, 0755)
+	if err != nil {
+		return list, err
+	}
+	for i := 0; i < len(fws.Entry); i++ {
+		fw := fws.Entry[i]
+		if fw.URI == "" {
+			continue
+		}
+		if m.Has(fw.URI) && VerifyImage(fw, m.LocalPath(fw.URI)) == nil {
+			continue
+		}
+		err = m.Fetch(fw)
+		if err != nil {
+			return list, fmt.Errorf("%s: %s", fw.Revision, err)
+		}
+		list = append(list, imageName(fw.URI))
+	}
+	return list, nil
+}
+
+// Fetch downloads a single Firmware image into the Mirror. The image is written
+// to a temporary file and only moved into place once it has been verified.
+func (m *Mirror) Fetch(fw *Firmware) error {
+	if Debug {
+		fmt.Printf("|-| GET %s |-|\n", fw.URI)
+	}
+	resp, err := HTTPClient.Get(fw.URI)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	dst := m.LocalPath(fw.URI)
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	f.Close()
+	if err == nil {
+		err = VerifyImage(fw, tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Handler returns an http.Handler serving the Mirror directory.
+func (m *Mirror) Handler() http.Handler {
+	return http.FileServer(http.Dir(m.Dir))
+}
+
+// Serve runs the built-in HTTP file server for the Mirror on Addr.
+func (m *Mirror) Serve() error {
+	if m.Addr == "" {
+		return errors.New("Missing Mirror Listen Address")
+	}
+	return http.ListenAndServe(m.Addr, m.Handler())
+}
+
+// VerifyImage checks a downloaded image against the Size, Digest and FirmwareHash
+// recorded by the FMS. Hashes are matched by their hex length (md5, sha1, sha256,
+// sha512); values that are empty or not recognised hex digests are not checked.
+func VerifyImage(fw *Firmware, file string) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if fw.Size > 0 && fi.Size() != int64(fw.Size) {
+		return fmt.Errorf("Size mismatch: expected %d, got %d", fw.Size, fi.Size())
+	}
+	for _, want := range []string{fw.Digest, fw.FirmwareHash} {
+		h := hasherFor(want)
+		if h == nil {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		got := hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(got, want) {
+			return fmt.Errorf("Digest mismatch: expected %s, got %s", want, got)
+		}
+	}
+	return nil
+}
+
+func hasherFor(digest string) hash.Hash {
+	if _, err := hex.DecodeString(digest); err != nil {
+		return nil
+	}
+	switch len(digest) {
+	case 32:
+		return md5.New()
+	case 40:
+		return sha1.New()
+	case 64:
+		return sha256.New()
+	case 128:
+		return sha512.New()
+	}
+	return nil
+}
+
+// imageName returns the file name portion of an image download URI, prefixed
+// with a hash of the whole URI so that images of the same name published at
+// different URIs are kept apart.
+func imageName(uri string) string {
+	base := path.Base(uri)
+	if u, err := url.Parse(uri); err == nil && u.Path != "" {
+		base = path.Base(u.Path)
+	}
+	sum := sha256.Sum256([]byte(uri))
+	return hex.EncodeToString(sum[:4]) + "-" + base
+}
@@ -0,0 +1,474 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultMonitorInterval is how often a Monitor polls when no Interval is set.
+const DefaultMonitorInterval = time.Minute
+
+// An EventType identifies what happened to a device.
+type EventType string
+
+// EventTypes emitted by a Monitor, and by a Campaign or UpgradeFailedEvent
+// for EventUpgradeFailed.
+const (
+	EventConnected       EventType = "connected"
+	EventDisconnected    EventType = "disconnected"
+	EventFirmwareChanged EventType = "firmware_changed"
+	EventConfigChanged   EventType = "config_changed"
+	EventDeviceAdded     EventType = "device_added"
+	EventDeviceRemoved   EventType = "device_removed"
+	EventConfigLagging   EventType = "config_lagging"
+	EventUpgradeFailed   EventType = "upgrade_failed"
+)
+
+// EventTypes lists every EventType.
+var EventTypes = []EventType{EventConnected, EventDisconnected, EventFirmwareChanged, EventConfigChanged, EventDeviceAdded, EventDeviceRemoved, EventConfigLagging, EventUpgradeFailed}
+
+// The Event object describes a change of state of a single device.
+type Event struct {
+	Type         EventType `json:"type"`
+	SerialNumber string    `json:"serialNumber"`
+	DeviceType   string    `json:"deviceType,omitempty"`
+	From         string    `json:"from,omitempty"`
+	To           string    `json:"to,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// GenerateDescription returns a string of concatenated values describing the Event object.
+func (e *Event) GenerateDescription() string {
+	desc := fmt.Sprintf("%s %s: %s, ", e.Time.Format(time.RFC3339), e.SerialNumber, e.Type)
+	if e.DeviceType != "" {
+		desc += fmt.Sprintf("Type: %s, ", e.DeviceType)
+	}
+	if e.From != "" || e.To != "" {
+		desc += fmt.Sprintf("%s -> %s, ", e.From, e.To)
+	}
+	return desc
+}
+
+// EventsFromTransitions converts the transitions between two snapshots into
+// Events, taking the DeviceType from whichever snapshot has the device.
+func EventsFromTransitions(prev, cur *Snapshot, list []*Transition) (events []*Event) {
+	for _, t := range list {
+		e := &Event{
+			SerialNumber: t.SerialNumber,
+			From:         t.From,
+			To:           t.To,
+			Time:         t.At,
+		}
+		if ds, ok := cur.Devices[t.SerialNumber]; ok {
+			e.DeviceType = ds.DeviceType
+		} else if ds, ok := prev.Devices[t.SerialNumber]; ok {
+			e.DeviceType = ds.DeviceType
+		}
+		switch t.Field {
+		case FieldStatus:
+			e.Type = EventDisconnected
+			if t.To == "UP" {
+				e.Type = EventConnected
+			}
+		case FieldRevision:
+			e.Type = EventFirmwareChanged
+		case FieldConfig:
+			e.Type = EventConfigChanged
+		case FieldAdded:
+			e.Type = EventDeviceAdded
+		case FieldRemoved:
+			e.Type = EventDeviceRemoved
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// A Sink receives the Events emitted by a Monitor, one at a time.
+type Sink interface {
+	Send(e *Event) error
+}
+
+// A SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(e *Event) error
+
+// Send calls the function.
+func (f SinkFunc) Send(e *Event) error {
+	return f(e)
+}
+
+// The WriterSink object writes each Event to W as a description line, or as a
+// JSON line when JSON is set.
+type WriterSink struct {
+	W    io.Writer
+	JSON bool
+}
+
+// Send writes the Event to the WriterSink.
+func (ws *WriterSink) Send(e *Event) error {
+	if !ws.JSON {
+		_, err := fmt.Fprintln(ws.W, e.GenerateDescription())
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(ws.W, "%s\n", data)
+	return err
+}
+
+// The Monitor object polls the GW and FMS at an Interval, compares each
+// Snapshot with the previous one and emits the resulting Events to its Sinks
+// and subscribers. The first poll only records the baseline, apart from the
+// ConfigLag check which reports devices that were already lagging.
+type Monitor struct {
+	UC       *UCentral
+	Interval time.Duration
+	Sinks    []Sink
+	OnError  func(err error) // receives poll and sink errors, def: ignored
+	Snapshot *Snapshot       // the most recent successful poll
+
+	// Reauthenticate is called before a poll once the OAuth2 token has expired,
+	// as a long running Monitor outlives the token it started with.
+	Reauthenticate func() error
+
+	// ConfigLag, when set, emits an EventConfigLagging once for each connected
+	// device whose configuration download lags the last change by more than
+	// this, and again only after the device caught up in between.
+	ConfigLag time.Duration
+
+	// Store, when set, receives every Event and the status of a device each
+	// time it changes. Every StatsInterval, def: DefaultStatsInterval, the
+	// status of every device is recorded again as a checkpoint, along with the
+	// statistics and health checks of the connected devices.
+	Store         *Store
+	StatsInterval time.Duration
+
+	// Notifications, when set, is run alongside the polls so that connection
+	// changes pushed by the GW are emitted as they arrive. A poll does not
+	// emit a connection change again once it was pushed.
+	Notifications *NotificationStream
+
+	lagging   map[string]bool
+	lastStats time.Time
+	recorded  map[string]bool   // Serial Number to the last status written to the Store
+	pushed    map[string]string // Serial Number to the last pushed status, until a poll sees it
+
+	mu   sync.Mutex
+	subs []chan *Event
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewMonitor returns a Monitor of the UCentral polling at the supplied interval.
+func NewMonitor(uc *UCentral, interval time.Duration) *Monitor {
+	return &Monitor{
+		UC:       uc,
+		Interval: interval,
+	}
+}
+
+// AddSink adds a Sink that receives every subsequent Event.
+func (m *Monitor) AddSink(s Sink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sinks = append(m.Sinks, s)
+}
+
+// Subscribe returns a channel receiving every subsequent Event. Events are
+// dropped for a subscriber whose buffer is full rather than stalling the
+// Monitor, and the channel is closed when Run returns.
+func (m *Monitor) Subscribe(buffer int) <-chan *Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan *Event, buffer)
+	m.subs = append(m.subs, ch)
+	return ch
+}
+
+// Stop ends Run after its current poll.
+func (m *Monitor) Stop() {
+	m.once.Do(func() {
+		close(m.done())
+	})
+}
+
+func (m *Monitor) done() chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop == nil {
+		m.stop = make(chan struct{})
+	}
+	return m.stop
+}
+
+// Run polls until Stop is called.
+func (m *Monitor) Run() error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultMonitorInterval
+	}
+	defer func() {
+		m.mu.Lock()
+		for _, ch := range m.subs {
+			close(ch)
+		}
+		m.subs = nil
+		m.mu.Unlock()
+	}()
+	var push <-chan *Notification
+	if m.Notifications != nil {
+		push = m.Notifications.Subscribe(64)
+		go m.Notifications.Run()
+		defer m.Notifications.Stop()
+	}
+	stop := m.done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	poll := true
+	for {
+		if poll {
+			_, err := m.Poll()
+			if err != nil {
+				m.error(err)
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-t.C:
+			poll = true
+		case n, ok := <-push:
+			if !ok {
+				push = nil
+			} else {
+				m.push(n)
+			}
+			poll = false
+		}
+	}
+}
+
+// push emits the Event of a connection Notification. It is called from Run
+// between polls, so it shares the Snapshot with Poll without locking.
+func (m *Monitor) push(n *Notification) {
+	e := n.Event()
+	if e == nil {
+		return
+	}
+	if m.Snapshot != nil {
+		if ds, ok := m.Snapshot.Devices[e.SerialNumber]; ok {
+			e.DeviceType = ds.DeviceType
+		}
+	}
+	if m.pushed == nil {
+		m.pushed = make(map[string]string)
+	}
+	m.pushed[e.SerialNumber] = e.To
+	m.Emit(e)
+	if m.Store != nil {
+		if m.recorded == nil {
+			m.recorded = make(map[string]bool)
+		}
+		m.recorded[e.SerialNumber] = e.To == "UP"
+		err := m.Store.Write(
+			&Record{Time: e.Time, SerialNumber: e.SerialNumber, Kind: RecordStatus, Connected: e.To == "UP"},
+			&Record{Time: e.Time, SerialNumber: e.SerialNumber, Kind: RecordEvent, Event: e},
+		)
+		if err != nil {
+			m.error(err)
+		}
+	}
+}
+
+// unpushed drops the connection Events already emitted by push, and forgets
+// the pushed status of every device the Snapshot has caught up with.
+func (m *Monitor) unpushed(s *Snapshot, events []*Event) []*Event {
+	if len(m.pushed) < 1 {
+		return events
+	}
+	var list []*Event
+	for _, e := range events {
+		if (e.Type == EventConnected || e.Type == EventDisconnected) && m.pushed[e.SerialNumber] == e.To {
+			continue
+		}
+		list = append(list, e)
+	}
+	for sn, status := range m.pushed {
+		if ds, ok := s.Devices[sn]; !ok || upDown(ds.Connected) == status {
+			delete(m.pushed, sn)
+		}
+	}
+	return list
+}
+
+// Poll takes a Snapshot, emits the Events since the previous one and returns them.
+func (m *Monitor) Poll() ([]*Event, error) {
+	if m.Reauthenticate != nil && !m.UC.OAuth2.Valid(time.Now(), 0) {
+		err := m.Reauthenticate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s, err := m.UC.TakeSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	prev := m.Snapshot
+	m.Snapshot = s
+	var events []*Event
+	if prev != nil {
+		events = m.unpushed(s, EventsFromTransitions(prev, s, s.Diff(prev)))
+	}
+	events = append(events, m.checkConfigLag(s)...)
+	for _, e := range events {
+		m.Emit(e)
+	}
+	if m.Store != nil {
+		if err = m.record(s, events); err != nil {
+			return events, err
+		}
+	}
+	return events, nil
+}
+
+// record writes the status of the devices that changed and the Events to the
+// Store, followed by a checkpoint of every device when one is due.
+func (m *Monitor) record(s *Snapshot, events []*Event) error {
+	interval := m.StatsInterval
+	if interval <= 0 {
+		interval = DefaultStatsInterval
+	}
+	checkpoint := s.Taken.Sub(m.lastStats) >= interval
+	if m.recorded == nil {
+		m.recorded = make(map[string]bool)
+	}
+	var recs []*Record
+	for _, sn := range s.SerialNumbers() {
+		connected := s.Devices[sn].Connected
+		if was, ok := m.recorded[sn]; ok && was == connected && !checkpoint {
+			continue
+		}
+		m.recorded[sn] = connected
+		recs = append(recs, &Record{Time: s.Taken, SerialNumber: sn, Kind: RecordStatus, Connected: connected})
+	}
+	for _, e := range events {
+		recs = append(recs, &Record{Time: e.Time, SerialNumber: e.SerialNumber, Kind: RecordEvent, Event: e})
+	}
+	err := m.Store.Write(recs...)
+	if err != nil {
+		return err
+	}
+	if !checkpoint {
+		return nil
+	}
+	m.lastStats = s.Taken
+	var sns []string
+	for _, sn := range s.SerialNumbers() {
+		if s.Devices[sn].Connected {
+			sns = append(sns, sn)
+		}
+	}
+	var mu sync.Mutex
+	recs = nil
+	RunBulk("statistics", sns, nil, func(sn string) (string, error) {
+		rec, err := m.statsRecord(sn)
+		if err != nil {
+			return "", err
+		}
+		mu.Lock()
+		recs = append(recs, rec)
+		mu.Unlock()
+		return "", nil
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	return m.Store.Write(recs...)
+}
+
+// statsRecord collects the metrics of a connected device, see Metrics.
+func (m *Monitor) statsRecord(sn string) (*Record, error) {
+	dev, err := m.UC.GetDevice(sn)
+	if err != nil {
+		return nil, err
+	}
+	in := &HealthInputs{
+		Device:  dev,
+		Status:  &FirmwareDevice{SerialNumber: sn, Status: "connected"},
+		Reboots: -1,
+	}
+	rec := &Record{Time: time.Now(), SerialNumber: sn, Kind: RecordStats, Values: make(map[string]float64)}
+	if hc, err := m.UC.GetDeviceHealthCheck(sn); err == nil {
+		in.HealthCheck = hc
+		rec.Values[MetricSanity] = float64(hc.Sanity)
+	}
+	if st, err := m.UC.GetDeviceStatistics(sn); err == nil {
+		in.Statistics = st
+		rec.Values[MetricUptime] = float64(st.Unit.Uptime)
+		rec.Values[MetricClients] = float64(st.Clients())
+		rec.Values[MetricMemoryFree] = float64(st.Unit.Memory.Free)
+		if len(st.Unit.Load) > 0 {
+			rec.Values[MetricLoad] = st.Unit.Load[0]
+		}
+	}
+	rec.Values[MetricScore] = float64(ScoreHealth(in, DefaultConfigLag, rec.Time).Score)
+	return rec, nil
+}
+
+// checkConfigLag returns an Event for each connected device that started
+// lagging behind its configuration since the previous poll.
+func (m *Monitor) checkConfigLag(s *Snapshot) (events []*Event) {
+	if m.ConfigLag <= 0 {
+		return nil
+	}
+	if m.lagging == nil {
+		m.lagging = make(map[string]bool)
+	}
+	for _, sn := range s.SerialNumbers() {
+		ds := s.Devices[sn]
+		lagging := ds.Connected && ds.ConfigLag > m.ConfigLag
+		if lagging && !m.lagging[sn] {
+			events = append(events, &Event{
+				Type:         EventConfigLagging,
+				SerialNumber: sn,
+				DeviceType:   ds.DeviceType,
+				To:           ds.ConfigLag.Round(time.Minute).String(),
+				Time:         s.Taken,
+			})
+		}
+		if lagging {
+			m.lagging[sn] = true
+		} else {
+			delete(m.lagging, sn)
+		}
+	}
+	return events
+}
+
+// Emit delivers an Event to every Sink and subscriber.
+func (m *Monitor) Emit(e *Event) {
+	m.mu.Lock()
+	sinks := append([]Sink{}, m.Sinks...)
+	for _, ch := range m.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	m.mu.Unlock()
+	for _, s := range sinks {
+		if err := s.Send(e); err != nil {
+			m.error(fmt.Errorf("%s %s: %s", e.SerialNumber, e.Type, err))
+		}
+	}
+}
+
+func (m *Monitor) error(err error) {
+	if m.OnError != nil {
+		m.OnError(err)
+	}
+}
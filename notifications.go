@@ -0,0 +1,388 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A NotificationType identifies a notification pushed by the GW.
+type NotificationType string
+
+// NotificationTypes pushed by the GW over its websocket.
+const (
+	NotificationConnected     NotificationType = "device_connection"
+	NotificationDisconnected  NotificationType = "device_disconnection"
+	NotificationState         NotificationType = "device_statistics"
+	NotificationHealthCheck   NotificationType = "device_healthcheck"
+	NotificationCommandResult NotificationType = "command_result"
+)
+
+// NotificationTypes lists every NotificationType that is decoded into a typed field.
+var NotificationTypes = []NotificationType{NotificationConnected, NotificationDisconnected, NotificationState, NotificationHealthCheck, NotificationCommandResult}
+
+// Reconnect delays of a NotificationStream when none are set.
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// notificationIdle is how long a connection may be silent, pings included,
+// before it is considered lost.
+const notificationIdle = 90 * time.Second
+
+// The Notification object is a single message pushed by the GW. Content holds
+// the raw message and, depending on the Type, one of State, HealthCheck or
+// Command is decoded from it.
+type Notification struct {
+	ID           int64            `json:"id"`
+	Type         NotificationType `json:"type"`
+	SerialNumber string           `json:"serialNumber,omitempty"`
+	Time         time.Time        `json:"time"`
+	Content      json.RawMessage  `json:"content,omitempty"`
+
+	State       *DeviceStatistics `json:"-"`
+	HealthCheck *HealthCheck      `json:"-"`
+	Command     *CommandResult    `json:"-"`
+}
+
+// The CommandResult object reports the outcome of a command sent to a device.
+type CommandResult struct {
+	UUID      string          `json:"UUID"`
+	Command   string          `json:"command"`
+	Status    string          `json:"status"`
+	ErrorCode int             `json:"errorCode"`
+	ErrorText string          `json:"errorText"`
+	Results   json.RawMessage `json:"results"`
+}
+
+// GenerateDescription returns a string of concatenated values describing the Notification object.
+func (n *Notification) GenerateDescription() string {
+	desc := fmt.Sprintf("%s %s: %s, ", n.Time.Format(time.RFC3339), n.SerialNumber, n.Type)
+	switch {
+	case n.HealthCheck != nil:
+		desc += fmt.Sprintf("Sanity: %d, ", n.HealthCheck.Sanity)
+	case n.State != nil:
+		desc += fmt.Sprintf("Uptime: %ds, Clients: %d, ", n.State.Unit.Uptime, n.State.Clients())
+	case n.Command != nil:
+		desc += fmt.Sprintf("Command: %s, Status: %s, ", n.Command.Command, n.Command.Status)
+		if n.Command.ErrorCode != 0 {
+			desc += fmt.Sprintf("Error: %d %s, ", n.Command.ErrorCode, n.Command.ErrorText)
+		}
+	}
+	return desc
+}
+
+// Event returns the Monitor Event of a connection notification, or nil for
+// any other Type, so that pushed notifications can feed the same Sinks.
+func (n *Notification) Event() *Event {
+	e := &Event{SerialNumber: n.SerialNumber, Time: n.Time}
+	switch n.Type {
+	case NotificationConnected:
+		e.Type, e.From, e.To = EventConnected, "DOWN", "UP"
+	case NotificationDisconnected:
+		e.Type, e.From, e.To = EventDisconnected, "UP", "DOWN"
+	default:
+		return nil
+	}
+	return e
+}
+
+var errNotNotification = errors.New("Not a Notification")
+
+// ParseNotification decodes a message pushed by the GW, which wraps the
+// content in {"notification": {"type": ..., "content": ...}, "notification_id": ...}.
+func ParseNotification(msg []byte) (*Notification, error) {
+	raw := &struct {
+		ID           int64 `json:"notification_id"`
+		Notification struct {
+			Type    string          `json:"type"`
+			Content json.RawMessage `json:"content"`
+		} `json:"notification"`
+	}{}
+	err := json.Unmarshal(msg, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw.Notification.Type == "" {
+		return nil, errNotNotification
+	}
+	n := &Notification{
+		ID:      raw.ID,
+		Type:    NotificationType(raw.Notification.Type),
+		Content: raw.Notification.Content,
+		Time:    time.Now(),
+	}
+	if len(n.Content) < 1 {
+		return n, nil
+	}
+	common := &struct {
+		SerialNumber string          `json:"serialNumber"`
+		Timestamp    int64           `json:"timestamp"`
+		Data         json.RawMessage `json:"data"`
+	}{}
+	err = json.Unmarshal(n.Content, &common)
+	if err != nil {
+		return nil, err
+	}
+	n.SerialNumber = common.SerialNumber
+	if common.Timestamp > 0 {
+		n.Time = time.Unix(common.Timestamp, 0)
+	}
+	// the payload is either nested under data or is the content itself
+	data := []byte(common.Data)
+	if len(data) < 1 {
+		data = n.Content
+	}
+	switch n.Type {
+	case NotificationState:
+		n.State = &DeviceStatistics{}
+		err = json.Unmarshal(data, n.State)
+	case NotificationHealthCheck:
+		n.HealthCheck = &HealthCheck{}
+		err = json.Unmarshal(data, n.HealthCheck)
+	case NotificationCommandResult:
+		n.Command = &CommandResult{}
+		err = json.Unmarshal(data, n.Command)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", n.Type, err)
+	}
+	return n, nil
+}
+
+// The NotificationStream object receives the notifications pushed by the GW
+// over its websocket. The stream authenticates with the OAuth2 token of the
+// UCentral and reconnects with exponential backoff whenever the connection is
+// lost, until Stop is called.
+type NotificationStream struct {
+	UC         *UCentral
+	Types      []NotificationType // delivered NotificationTypes, all if empty
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	OnError    func(err error) // receives connection and decoding errors, def: ignored
+
+	// Reauthenticate is called before connecting once the OAuth2 token has
+	// expired, as a long running stream outlives the token it started with.
+	Reauthenticate func() error
+
+	mu   sync.Mutex
+	subs []chan *Notification
+	conn *wsConn
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewNotificationStream returns a NotificationStream of the UCentral delivering
+// the supplied NotificationTypes, or all of them when none are supplied.
+func (uc *UCentral) NewNotificationStream(types ...NotificationType) *NotificationStream {
+	return &NotificationStream{
+		UC:    uc,
+		Types: types,
+	}
+}
+
+// Subscribe returns a channel receiving every subsequent Notification.
+// Notifications are dropped for a subscriber whose buffer is full rather than
+// stalling the stream, and the channel is closed when Run returns.
+func (ns *NotificationStream) Subscribe(buffer int) <-chan *Notification {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ch := make(chan *Notification, buffer)
+	ns.subs = append(ns.subs, ch)
+	return ch
+}
+
+// Stop closes the current connection and ends Run.
+func (ns *NotificationStream) Stop() {
+	ns.once.Do(func() {
+		close(ns.done())
+		ns.mu.Lock()
+		if ns.conn != nil {
+			ns.conn.Close()
+		}
+		ns.mu.Unlock()
+	})
+}
+
+func (ns *NotificationStream) done() chan struct{} {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.stop == nil {
+		ns.stop = make(chan struct{})
+	}
+	return ns.stop
+}
+
+// Run connects and delivers notifications until Stop is called.
+func (ns *NotificationStream) Run() error {
+	minBackoff, maxBackoff := ns.MinBackoff, ns.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = DefaultMaxBackoff
+	}
+	defer func() {
+		ns.mu.Lock()
+		for _, ch := range ns.subs {
+			close(ch)
+		}
+		ns.subs = nil
+		ns.mu.Unlock()
+	}()
+	stop := ns.done()
+	backoff := minBackoff
+	for {
+		connected, err := ns.session()
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if connected {
+			backoff = minBackoff
+		}
+		if err != nil {
+			ns.error(fmt.Errorf("Notification Stream: %s, reconnecting in %s", err, backoff))
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session connects, authenticates and reads notifications until the
+// connection is lost, reporting whether authentication succeeded.
+func (ns *NotificationStream) session() (bool, error) {
+	if ns.Reauthenticate != nil && !ns.UC.OAuth2.Valid(time.Now(), 0) {
+		if err := ns.Reauthenticate(); err != nil {
+			return false, err
+		}
+	}
+	if ns.UC.GW == "" || ns.UC.OAuth2 == nil || ns.UC.OAuth2.AccessToken == "" {
+		return false, errors.New("Must authenticate first")
+	}
+	ws, err := dialWebsocket(ns.UC.GW, "/api/v1/ws", 30*time.Second)
+	if err != nil {
+		return false, err
+	}
+	ns.mu.Lock()
+	select {
+	case <-ns.stop:
+		ns.mu.Unlock()
+		ws.Close()
+		return false, nil
+	default:
+	}
+	ns.conn = ws
+	ns.mu.Unlock()
+	defer func() {
+		ns.mu.Lock()
+		ns.conn = nil
+		ns.mu.Unlock()
+		ws.Close()
+	}()
+
+	err = ws.WriteText("token:" + ns.UC.OAuth2.AccessToken)
+	if err != nil {
+		return false, err
+	}
+	ws.SetReadDeadline(time.Now().Add(notificationIdle))
+	msg, err := ws.ReadMessage()
+	if err != nil {
+		return false, err
+	}
+	if err = authReply(msg); err != nil {
+		return false, err
+	}
+	ns.deliver(msg)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		t := time.NewTicker(notificationIdle / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-t.C:
+				if ws.Ping() != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		ws.SetReadDeadline(time.Now().Add(notificationIdle))
+		msg, err = ws.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		ns.deliver(msg)
+	}
+}
+
+// authReply checks the GW's reply to the token, which is either an error or
+// an acknowledgement that carries no notification.
+func authReply(msg []byte) error {
+	reply := &struct {
+		Error   string `json:"error"`
+		Success string `json:"success"`
+	}{}
+	if json.Unmarshal(msg, &reply) != nil {
+		return nil
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("Websocket Authentication Failed: %s", reply.Error)
+	}
+	return nil
+}
+
+func (ns *NotificationStream) deliver(msg []byte) {
+	n, err := ParseNotification(msg)
+	if err != nil {
+		if Debug {
+			fmt.Printf("|+| %s |+|\n", msg)
+		}
+		if err != errNotNotification {
+			ns.error(err)
+		}
+		return
+	}
+	if len(ns.Types) > 0 && !containsNotificationType(ns.Types, n.Type) {
+		return
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	for _, ch := range ns.subs {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+func (ns *NotificationStream) error(err error) {
+	if ns.OnError != nil {
+		ns.OnError(err)
+	}
+}
+
+func containsNotificationType(list []NotificationType, t NotificationType) bool {
+	for _, v := range list {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package tipWifi
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// OutputFormats lists the formats accepted by Report.Write. A Go template is
+// supplied as "template=<text>", ex: template={{.serialNumber}} {{.status}}
+var OutputFormats = []string{"table", "json", "yaml", "csv", "template"}
+
+// The Report object is a table of structured rows that can be written in any
+// of the OutputFormats. Columns holds the row keys in display order, named
+// like the JSON fields they are taken from so that templates and parsers see
+// the same names.
+type Report struct {
+	Title   string
+	Columns []string
+	Rows    []Row
+}
+
+// A Row maps Report column names to values.
+type Row map[string]interface{}
+
+// NewReport returns an empty Report with the supplied columns.
+func NewReport(title string, columns ...string) *Report {
+	return &Report{
+		Title:   title,
+		Columns: columns,
+	}
+}
+
+// Add appends a Row to the Report, taking the values in column order.
+func (r *Report) Add(values ...interface{}) {
+	row := make(Row, len(r.Columns))
+	for i := 0; i < len(r.Columns) && i < len(values); i++ {
+		row[r.Columns[i]] = values[i]
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// Append adds the rows of another Report with the same columns.
+func (r *Report) Append(other *Report) {
+	r.Rows = append(r.Rows, other.Rows...)
+}
+
+// Write renders the Report to w in the supplied format.
+func (r *Report) Write(w io.Writer, format string) error {
+	if strings.HasPrefix(format, "template=") {
+		return r.writeTemplate(w, strings.TrimPrefix(format, "template="))
+	}
+	switch format {
+	case "", "table":
+		return r.writeTable(w)
+	case "json":
+		return r.writeJSON(w)
+	case "yaml":
+		return r.writeYAML(w)
+	case "csv":
+		return r.writeCSV(w)
+	case "template":
+		return errors.New("Template format requires template=<text>")
+	}
+	return fmt.Errorf("Unknown output format %q, must be one of %v", format, OutputFormats)
+}
+
+func (r *Report) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if r.Title != "" {
+		fmt.Fprintf(tw, "# %s\n", r.Title)
+	}
+	header := make([]string, len(r.Columns))
+	for i, c := range r.Columns {
+		header[i] = strings.ToUpper(c)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range r.Rows {
+		fields := make([]string, len(r.Columns))
+		for i, c := range r.Columns {
+			fields[i] = formatValue(row[c])
+		}
+		fmt.Fprintln(tw, strings.Join(fields, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeJSON writes the rows as an array of objects, keeping the column order.
+func (r *Report) writeJSON(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for n, row := range r.Rows {
+		if n > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {")
+		for i, c := range r.Columns {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			k, _ := json.Marshal(c)
+			v, err := json.Marshal(row[c])
+			if err != nil {
+				return err
+			}
+			buf.Write(k)
+			buf.WriteString(": ")
+			buf.Write(v)
+		}
+		buf.WriteString("}")
+	}
+	if len(r.Rows) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeYAML writes the rows as a sequence of mappings, keeping the column order.
+// Strings are written as JSON strings, which are valid YAML scalars.
+func (r *Report) writeYAML(w io.Writer) error {
+	var buf bytes.Buffer
+	if len(r.Rows) < 1 {
+		buf.WriteString("[]\n")
+	}
+	for _, row := range r.Rows {
+		for i, c := range r.Columns {
+			prefix := "  "
+			if i == 0 {
+				prefix = "- "
+			}
+			v, err := yamlValue(row[c])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&buf, "%s%s: %s\n", prefix, c, v)
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func yamlValue(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "null", nil
+	case []string:
+		if len(val) < 1 {
+			return "[]", nil
+		}
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(r.Columns)
+	for _, row := range r.Rows {
+		fields := make([]string, len(r.Columns))
+		for i, c := range r.Columns {
+			fields[i] = formatValue(row[c])
+		}
+		cw.Write(fields)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTemplate executes the template once per Row, adding a newline after
+// each row unless the template ends with one.
+func (r *Report) writeTemplate(w io.Writer, text string) error {
+	tmpl, err := template.New("row").Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		err = tmpl.Execute(w, row)
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(text, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
+
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case []string:
+		return strings.Join(val, ",")
+	case fmt.Stringer:
+		return val.String()
+	}
+	return fmt.Sprint(v)
+}
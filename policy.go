@@ -0,0 +1,252 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PolicyDecision values.
+const (
+	PolicyAllow  = "allow"
+	PolicyDefer  = "defer"  // not now, but may be allowed later, ex: outside a maintenance window
+	PolicyRefuse = "refuse" // never for this image
+)
+
+// The PolicyError object is returned when an upgrade is refused or deferred by policy.
+type PolicyError struct {
+	Decision string
+	Reason   string
+}
+
+func (pe *PolicyError) Error() string {
+	return fmt.Sprintf("Upgrade %s by policy: %s", pe.Decision, pe.Reason)
+}
+
+// IsPolicyDeferred returns whether the error is a PolicyError deferring the upgrade.
+func IsPolicyDeferred(err error) bool {
+	pe, ok := err.(*PolicyError)
+	return ok && pe.Decision == PolicyDefer
+}
+
+// The PolicyEngine object holds the locally defined UpgradePolicy rules.
+// Rules are evaluated in order and every matching rule must allow the upgrade.
+type PolicyEngine struct {
+	Rules []*UpgradePolicy `json:"policies"`
+}
+
+// The UpgradePolicy object is a locally defined rule restricting firmware upgrades.
+// A rule with no Venues, DeviceTypes or SerialNumbers applies to every device.
+type UpgradePolicy struct {
+	Name            string               `json:"name"`
+	Venues          []string             `json:"venues,omitempty"`
+	DeviceTypes     []string             `json:"deviceTypes,omitempty"`
+	SerialNumbers   []string             `json:"serialNumbers,omitempty"` // glob patterns allowed, ex: 903cb3*
+	Timezone        string               `json:"timezone,omitempty"`      // IANA name for the Windows, def: UTC
+	Windows         []*MaintenanceWindow `json:"windows,omitempty"`
+	PinnedRevision  string               `json:"pinnedRevision,omitempty"`
+	BlockedReleases []string             `json:"blockedReleases,omitempty"` // exact values or globs matched against Release and Revision
+	Channel         string               `json:"channel,omitempty"`         // "stable" or "rc", def: stable
+}
+
+// The MaintenanceWindow object is a daily period during which upgrades may run.
+// Days uses three letter names (mon, tue, ...), an empty list means every day.
+// A window whose End is before its Start runs past midnight.
+type MaintenanceWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"` // ex: 02:00
+	End   string   `json:"end"`   // ex: 05:00
+}
+
+// LoadPolicyEngine reads the UpgradePolicy rules from a JSON file, rejecting
+// an unknown Timezone or a malformed MaintenanceWindow rather than letting the
+// rule apply at the wrong hours.
+func LoadPolicyEngine(file string) (*PolicyEngine, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pe := &PolicyEngine{}
+	err = json.Unmarshal(data, &pe)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range pe.Rules {
+		if _, err = time.LoadLocation(rule.Timezone); err != nil {
+			return nil, fmt.Errorf("%s: policy %q: invalid timezone %q", file, rule.Name, rule.Timezone)
+		}
+		for _, w := range rule.Windows {
+			_, err1 := time.Parse("15:04", w.Start)
+			_, err2 := time.Parse("15:04", w.End)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("%s: policy %q: window times must be HH:MM, not %q-%q", file, rule.Name, w.Start, w.End)
+			}
+		}
+		for _, blocked := range rule.BlockedReleases {
+			if _, err = path.Match(blocked, ""); err != nil {
+				return nil, fmt.Errorf("%s: policy %q: invalid blocked release %q", file, rule.Name, blocked)
+			}
+		}
+	}
+	return pe, nil
+}
+
+// Evaluate returns nil if the Device may be upgraded to the Firmware at the
+// supplied time, and a PolicyError otherwise. The GW's FwUpdatePolicy of the
+// Device is checked first, then each matching local rule.
+func (pe *PolicyEngine) Evaluate(dev *Device, fw *Firmware, now time.Time) error {
+	switch strings.ToLower(dev.FwUpdatePolicy) {
+	case "none", "never", "manual", "disabled":
+		return &PolicyError{PolicyRefuse, fmt.Sprintf("device fwUpdatePolicy is %q", dev.FwUpdatePolicy)}
+	case "rc", "beta", "release-candidate":
+		// the device opts in to release candidates, local rules may still restrict it
+	default:
+		if isReleaseCandidate(fw) && pe.channelFor(dev) != "rc" {
+			return &PolicyError{PolicyRefuse, fmt.Sprintf("%s is a release candidate and device is on the stable channel", fw.Revision)}
+		}
+	}
+	if pe == nil {
+		return nil
+	}
+	for _, rule := range pe.Rules {
+		if !rule.Matches(dev) {
+			continue
+		}
+		if err := rule.evaluate(fw, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// channelFor returns the channel of the first matching rule that sets one.
+func (pe *PolicyEngine) channelFor(dev *Device) string {
+	if strings.EqualFold(dev.FwUpdatePolicy, "rc") {
+		return "rc"
+	}
+	if pe == nil {
+		return "stable"
+	}
+	for _, rule := range pe.Rules {
+		if rule.Channel != "" && rule.Matches(dev) {
+			return strings.ToLower(rule.Channel)
+		}
+	}
+	return "stable"
+}
+
+// Matches returns whether the UpgradePolicy applies to the Device.
+func (up *UpgradePolicy) Matches(dev *Device) bool {
+	if len(up.Venues) > 0 && !existsInList(dev.Venue, up.Venues) {
+		return false
+	}
+	if len(up.DeviceTypes) > 0 && !existsInList(dev.DeviceType, up.DeviceTypes) {
+		return false
+	}
+	if len(up.SerialNumbers) > 0 {
+		for _, pattern := range up.SerialNumbers {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(dev.SerialNumber)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (up *UpgradePolicy) evaluate(fw *Firmware, now time.Time) error {
+	if up.PinnedRevision != "" && fw.Revision != up.PinnedRevision {
+		return &PolicyError{PolicyRefuse, fmt.Sprintf("%s pins revision %s", up.Name, up.PinnedRevision)}
+	}
+	for _, blocked := range up.BlockedReleases {
+		if blocked != "" && (matchRelease(blocked, fw.Release) || matchRelease(blocked, fw.Revision)) {
+			return &PolicyError{PolicyRefuse, fmt.Sprintf("%s blocks %s", up.Name, blocked)}
+		}
+	}
+	if strings.ToLower(up.Channel) == "stable" && isReleaseCandidate(fw) {
+		return &PolicyError{PolicyRefuse, fmt.Sprintf("%s is on the stable channel", up.Name)}
+	}
+	if len(up.Windows) > 0 {
+		loc, err := time.LoadLocation(up.Timezone)
+		if err != nil {
+			return fmt.Errorf("%s: invalid timezone %q", up.Name, up.Timezone)
+		}
+		local := now.In(loc)
+		for _, w := range up.Windows {
+			if w.Contains(local) {
+				return nil
+			}
+		}
+		return &PolicyError{PolicyDefer, fmt.Sprintf("%s is outside its maintenance window", up.Name)}
+	}
+	return nil
+}
+
+// matchRelease reports whether the value is the blocked release, or matches it
+// as a glob, ignoring case.
+func matchRelease(blocked, value string) bool {
+	if value == "" {
+		return false
+	}
+	ok, _ := path.Match(strings.ToLower(blocked), strings.ToLower(value))
+	return ok
+}
+
+// Contains returns whether the supplied local time falls within the MaintenanceWindow.
+func (mw *MaintenanceWindow) Contains(t time.Time) bool {
+	start, err1 := time.Parse("15:04", mw.Start)
+	end, err2 := time.Parse("15:04", mw.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	mins := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	day := t
+	inWindow := mins >= from && mins < to
+	if to <= from {
+		// window runs past midnight, the early part belongs to the previous day
+		inWindow = mins >= from || mins < to
+		if mins < to {
+			day = t.AddDate(0, 0, -1)
+		}
+	}
+	if !inWindow {
+		return false
+	}
+	if len(mw.Days) < 1 {
+		return true
+	}
+	return existsInList(strings.ToLower(day.Weekday().String()[:3]), mw.Days)
+}
+
+// CheckUpgradePolicy fetches the GW Device for the Serial Number and evaluates
+// whether it may be upgraded to the Firmware now.
+func (uc *UCentral) CheckUpgradePolicy(sn string, fw *Firmware) error {
+	dev, err := uc.GetDevice(sn)
+	if err != nil {
+		return err
+	}
+	return uc.Policy.Evaluate(dev, fw, time.Now())
+}
+
+// rcToken matches a release candidate marker such as "-rc", "rc2" or "2.5rc1",
+// but not "rc" inside a word such as "src" or "force".
+var rcToken = regexp.MustCompile(`(^|[^a-z])rc([0-9]+|[^a-z]|$)`)
+
+func isReleaseCandidate(fw *Firmware) bool {
+	return rcToken.MatchString(strings.ToLower(fw.Release)) || rcToken.MatchString(strings.ToLower(fw.Revision))
+}
+
+func existsInList(s string, l []string) bool {
+	for _, v := range l {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
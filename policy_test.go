@@ -0,0 +1,85 @@
+package tipWifi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 1, day, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		mw   MaintenanceWindow
+		t    time.Time
+		want bool
+	}{
+		{"inside", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(1, "03:30"), true},
+		{"at start", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(1, "02:00"), true},
+		{"at end", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(1, "05:00"), false},
+		{"before", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(1, "01:59"), false},
+		{"day listed", MaintenanceWindow{Days: []string{"mon"}, Start: "02:00", End: "05:00"}, at(1, "03:00"), true},
+		{"day not listed", MaintenanceWindow{Days: []string{"tue"}, Start: "02:00", End: "05:00"}, at(1, "03:00"), false},
+		{"day case", MaintenanceWindow{Days: []string{"Mon"}, Start: "02:00", End: "05:00"}, at(1, "03:00"), true},
+		{"past midnight, evening", MaintenanceWindow{Days: []string{"mon"}, Start: "22:00", End: "03:00"}, at(1, "23:00"), true},
+		{"past midnight, morning after", MaintenanceWindow{Days: []string{"mon"}, Start: "22:00", End: "03:00"}, at(2, "01:00"), true},
+		{"past midnight, morning of", MaintenanceWindow{Days: []string{"mon"}, Start: "22:00", End: "03:00"}, at(1, "01:00"), false},
+		{"past midnight, outside", MaintenanceWindow{Start: "22:00", End: "03:00"}, at(1, "12:00"), false},
+		{"invalid start", MaintenanceWindow{Start: "2am", End: "05:00"}, at(1, "03:00"), false},
+	}
+	for _, tt := range tests {
+		if got := tt.mw.Contains(tt.t); got != tt.want {
+			t.Errorf("%s: Contains(%s) = %t, want %t", tt.name, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestIsReleaseCandidate(t *testing.T) {
+	tests := []struct {
+		release  string
+		revision string
+		want     bool
+	}{
+		{"", "TIP-v2.5.0-rc1-abcdef", true},
+		{"v2.5.0-RC2", "", true},
+		{"2.5rc1", "", true},
+		{"rc", "", true},
+		{"", "TIP-v2.5.0-abcdef", false},
+		{"source build", "", false},
+		{"force-upgrade", "", false},
+		{"", "TIP-v2.5.0-rcx", false},
+	}
+	for _, tt := range tests {
+		fw := &Firmware{Release: tt.release, Revision: tt.revision}
+		if got := isReleaseCandidate(fw); got != tt.want {
+			t.Errorf("isReleaseCandidate(%q, %q) = %t, want %t", tt.release, tt.revision, got, tt.want)
+		}
+	}
+}
+
+func TestUpgradePolicyBlockedReleases(t *testing.T) {
+	tests := []struct {
+		blocked  string
+		release  string
+		revision string
+		want     bool
+	}{
+		{"v2.5.0", "v2.5.0", "", true},
+		{"v2.5.0", "V2.5.0", "", true},
+		{"v2.5.0", "v2.5.0.1", "", false},
+		{"v2.5", "v2.5.0", "", false},
+		{"v2.5.*", "v2.5.0", "", true},
+		{"TIP-v2.5.0-*", "", "TIP-v2.5.0-abcdef", true},
+		{"TIP-v2.5.0-*", "", "TIP-v2.6.0-abcdef", false},
+	}
+	for _, tt := range tests {
+		up := &UpgradePolicy{Name: "test", BlockedReleases: []string{tt.blocked}}
+		err := up.evaluate(&Firmware{Release: tt.release, Revision: tt.revision}, time.Now())
+		if got := err != nil; got != tt.want {
+			t.Errorf("blocked %q, release %q, revision %q: blocked = %t, want %t", tt.blocked, tt.release, tt.revision, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package tipWifi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The FirmwareHistory object is the release history of a single DeviceType,
+// ordered from the newest to the oldest image.
+type FirmwareHistory struct {
+	DeviceType string
+	Entry      []*Firmware
+}
+
+// The FirmwareChangelog object describes what changed between the revision a
+// device is running and the latest image available for its DeviceType.
+type FirmwareChangelog struct {
+	SerialNumber string
+	DeviceType   string
+	Current      *Firmware   // nil when the running revision is not in the FMS registry
+	Revision     string      // revision reported by the device
+	Latest       *Firmware   // newest image of the DeviceType
+	Releases     []*Firmware // images newer than the running revision, newest first
+}
+
+// GetFirmwareHistory returns every Firmware registered for the DeviceType sorted by ImageDate.
+func (uc *UCentral) GetFirmwareHistory(devType string) (*FirmwareHistory, error) {
+	fws, err := uc.GetFirmwareListByDevice(devType)
+	if err != nil {
+		return nil, err
+	}
+	fws.SortByImageDate()
+	return &FirmwareHistory{
+		DeviceType: devType,
+		Entry:      fws.Entry,
+	}, nil
+}
+
+// Since returns the images released after the supplied revision, newest first.
+// If the revision is unknown every image is returned.
+func (h *FirmwareHistory) Since(rev string) []*Firmware {
+	for i := 0; i < len(h.Entry); i++ {
+		if h.Entry[i].Revision == rev {
+			return h.Entry[:i]
+		}
+	}
+	return h.Entry
+}
+
+// GenerateList returns a list of each Firmware entry's history description.
+func (h *FirmwareHistory) GenerateList() (list []string) {
+	for i := 0; i < len(h.Entry); i++ {
+		list = append(list, h.Entry[i].GenerateHistoryEntry())
+	}
+	return list
+}
+
+// Report returns a Report with one history row per Firmware entry.
+func (h *FirmwareHistory) Report() *Report {
+	return historyReport(h.DeviceType, h.Entry)
+}
+
+// GetFirmwareChangelog compares the revision a device is running with the
+// release history of its DeviceType.
+func (uc *UCentral) GetFirmwareChangelog(sn string) (*FirmwareChangelog, error) {
+	fwd, err := uc.GetFirmwareDevice(sn)
+	if err != nil {
+		return nil, err
+	}
+	h, err := uc.GetFirmwareHistory(fwd.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.Entry) < 1 {
+		return nil, errors.New("No Firmware Registered for Device Type")
+	}
+	cl := &FirmwareChangelog{
+		SerialNumber: sn,
+		DeviceType:   fwd.DeviceType,
+		Revision:     fwd.Revision,
+		Latest:       h.Entry[0],
+		Releases:     h.Since(fwd.Revision),
+	}
+	if len(cl.Releases) < len(h.Entry) {
+		cl.Current = h.Entry[len(cl.Releases)]
+	}
+	return cl, nil
+}
+
+// GenerateList returns a summary line followed by the history description of
+// every release between the running revision and the latest.
+func (cl *FirmwareChangelog) GenerateList() (list []string) {
+	desc := fmt.Sprintf("Current: %s, ", cl.Revision)
+	desc += fmt.Sprintf("Latest: %s, ", cl.Latest.Revision)
+	desc += fmt.Sprintf("Releases Behind: %d, ", len(cl.Releases))
+	if cl.Current == nil {
+		desc += "Current revision not in FMS registry, "
+	}
+	list = append(list, desc)
+	for i := 0; i < len(cl.Releases); i++ {
+		list = append(list, cl.Releases[i].GenerateHistoryEntry())
+	}
+	return list
+}
+
+// Report returns a Report with one history row per release between the running
+// revision and the latest.
+func (cl *FirmwareChangelog) Report() *Report {
+	title := fmt.Sprintf("%s: %s -> %s, %d release(s) behind", cl.SerialNumber, cl.Revision, cl.Latest.Revision, len(cl.Releases))
+	return historyReport(title, cl.Releases)
+}
+
+func historyReport(title string, fws []*Firmware) *Report {
+	r := NewReport(title, "imageDate", "release", "revision", "size", "digest", "uploader", "notes")
+	for _, fw := range fws {
+		var notes []string
+		for _, n := range fw.Notes {
+			notes = append(notes, fmt.Sprintf("%s: %s", n.CreatedBy, n.Note))
+		}
+		r.Add(time.Unix(int64(fw.ImageDate), 0).UTC().Format("2006-01-02"), fw.Release, fw.Revision, fw.Size, fw.Digest, fw.Uploader, notes)
+	}
+	return r
+}
+
+// GenerateHistoryEntry returns a string of concatenated values describing the
+// Firmware object as a release history entry, including its notes.
+func (fw *Firmware) GenerateHistoryEntry() string {
+	desc := fmt.Sprintf("%s: ", time.Unix(int64(fw.ImageDate), 0).UTC().Format("2006-01-02"))
+	desc += fmt.Sprintf("Release: %s, ", fw.Release)
+	desc += fmt.Sprintf("Revision: %s, ", fw.Revision)
+	desc += fmt.Sprintf("Size: %d, ", fw.Size)
+	desc += fmt.Sprintf("Digest: %s, ", fw.Digest)
+	desc += fmt.Sprintf("Uploader: %s, ", fw.Uploader)
+	for i := 0; i < len(fw.Notes); i++ {
+		desc += fmt.Sprintf("Note (%s): %s, ", fw.Notes[i].CreatedBy, fw.Notes[i].Note)
+	}
+	return desc
+}
@@ -0,0 +1,464 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// Campaign and CampaignDevice status values.
+const (
+	CampaignPending   = "pending"
+	CampaignRunning   = "running"
+	CampaignPaused    = "paused"
+	CampaignHalted    = "halted"
+	CampaignComplete  = "complete"
+	CampaignDeferred  = "deferred" // all devices handled except those deferred by policy
+	DevicePending     = "pending"
+	DeviceUpgrading   = "upgrading"
+	DeviceDone        = "done"
+	DeviceFailed      = "failed"
+	DeviceSkipped     = "skipped"
+	DeviceDeferred    = "deferred"
+	defaultCanarySize = 1
+	defaultWaveGrowth = 2
+)
+
+// ErrCampaignPaused is returned by Run when a pause was requested between waves.
+var ErrCampaignPaused = errors.New("Campaign Paused")
+
+// A DeviceSelector reports whether a FirmwareDevice should be included in a Campaign.
+type DeviceSelector func(fwd *FirmwareDevice) bool
+
+// SelectByDeviceType returns a DeviceSelector matching every device of the supplied type.
+func SelectByDeviceType(devType string) DeviceSelector {
+	return func(fwd *FirmwareDevice) bool {
+		return fwd.DeviceType == devType
+	}
+}
+
+// SelectBySerials returns a DeviceSelector matching the supplied Serial Numbers.
+func SelectBySerials(sns []string) DeviceSelector {
+	return func(fwd *FirmwareDevice) bool {
+		for i := 0; i < len(sns); i++ {
+			if sns[i] == fwd.SerialNumber {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// The Campaign object describes a staged firmware rollout. A canary wave of
+// CanarySize devices is upgraded first, and each following wave is WaveGrowth
+// times larger than the previous one. The campaign halts once more than
+// FailureBudget devices have failed, and each failed device is sent to the
+// Alerts Sink as an EventUpgradeFailed when one is set. All progress is saved
+// to the StateFile after every step, so an interrupted campaign can be loaded
+// and Run again.
+type Campaign struct {
+	Name             string            `json:"name"`
+	DeviceType       string            `json:"deviceType,omitempty"`
+	SerialNumbers    []string          `json:"serialNumbers,omitempty"`
+	TargetURI        string            `json:"targetUri,omitempty"`
+	TargetRevision   string            `json:"targetRevision,omitempty"`
+	CanarySize       int               `json:"canarySize,omitempty"`
+	WaveGrowth       int               `json:"waveGrowth,omitempty"`
+	FailureBudget    int               `json:"failureBudget"`
+	MinSanity        int               `json:"minSanity,omitempty"`        // minimum HealthCheck Sanity after upgrade, 0 to skip
+	ReconnectTimeout int               `json:"reconnectTimeout,omitempty"` // seconds, def: 600
+	PollInterval     int               `json:"pollInterval,omitempty"`     // seconds, def: 15
+	Status           string            `json:"status"`
+	Wave             int               `json:"wave"`
+	Devices          []*CampaignDevice `json:"devices"`
+	StateFile        string            `json:"-"`
+	Alerts           Sink              `json:"-"`
+}
+
+// The CampaignDevice object tracks the rollout progress of a single device.
+type CampaignDevice struct {
+	SerialNumber     string `json:"serialNumber"`
+	PreviousRevision string `json:"previousRevision,omitempty"`
+	Revision         string `json:"revision,omitempty"`
+	Wave             int    `json:"wave,omitempty"`
+	Status           string `json:"status"`
+	Error            string `json:"error,omitempty"`
+	Updated          int    `json:"updated,omitempty"`
+}
+
+// LoadCampaign reads a Campaign from the supplied state file.
+func LoadCampaign(path string) (*Campaign, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Campaign{}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, err
+	}
+	c.StateFile = path
+	if c.Status == "" {
+		c.Status = CampaignPending
+	}
+	if err = c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validate fills in the default wave sizing and rejects one that cannot
+// grow, so that every wave upgrades at least one more device.
+func (c *Campaign) validate() error {
+	if c.CanarySize == 0 {
+		c.CanarySize = defaultCanarySize
+	}
+	if c.WaveGrowth == 0 {
+		c.WaveGrowth = defaultWaveGrowth
+	}
+	if c.CanarySize < 1 {
+		return fmt.Errorf("Campaign canarySize must be at least 1, not %d", c.CanarySize)
+	}
+	if c.WaveGrowth < 1 {
+		return fmt.Errorf("Campaign waveGrowth must be at least 1, not %d", c.WaveGrowth)
+	}
+	return nil
+}
+
+// Save writes the Campaign to its StateFile.
+func (c *Campaign) Save() error {
+	if c.StateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.StateFile + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.StateFile)
+}
+
+// Pause requests that a running Campaign stops before its next wave.
+// The request is a marker file next to the StateFile so that it can be issued
+// from a separate process.
+func (c *Campaign) Pause() error {
+	return ioutil.WriteFile(c.StateFile+".pause", []byte{}, 0644)
+}
+
+// Resume clears a pause request, after which the Campaign can be Run again.
+func (c *Campaign) Resume() error {
+	err := os.Remove(c.StateFile + ".pause")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *Campaign) pauseRequested() bool {
+	_, err := os.Stat(c.StateFile + ".pause")
+	return err == nil
+}
+
+// Prepare resolves the target firmware and the device list of a new Campaign.
+// Devices are selected from the FMS by SerialNumbers if supplied, otherwise by DeviceType.
+func (c *Campaign) Prepare(uc *UCentral) error {
+	if len(c.Devices) > 0 {
+		return nil
+	}
+	var sel DeviceSelector
+	switch {
+	case len(c.SerialNumbers) > 0:
+		sel = SelectBySerials(c.SerialNumbers)
+	case c.DeviceType != "":
+		sel = SelectByDeviceType(c.DeviceType)
+	default:
+		return errors.New("Campaign requires Serial Numbers or a Device Type")
+	}
+	fwds, err := uc.GetAllFirmwareDevices()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(fwds.Entry); i++ {
+		if !sel(fwds.Entry[i]) {
+			continue
+		}
+		c.Devices = append(c.Devices, &CampaignDevice{
+			SerialNumber: fwds.Entry[i].SerialNumber,
+			Revision:     fwds.Entry[i].Revision,
+			Status:       DevicePending,
+		})
+		if c.DeviceType == "" {
+			c.DeviceType = fwds.Entry[i].DeviceType
+		}
+	}
+	if len(c.Devices) < 1 {
+		return errors.New("No Devices Matched Campaign")
+	}
+	if c.TargetURI == "" {
+		fw, err := uc.GetLatestFirmwareByDevice(c.DeviceType)
+		if err != nil {
+			return err
+		}
+		c.TargetURI = fw.URI
+		c.TargetRevision = fw.Revision
+	} else if c.TargetRevision == "" {
+		fws, err := uc.GetFirmwareListByDevice(c.DeviceType)
+		if err == nil {
+			if fw := fws.FindByURI(c.TargetURI); fw != nil {
+				c.TargetRevision = fw.Revision
+			}
+		}
+	}
+	c.Status = CampaignPending
+	return c.Save()
+}
+
+// Run executes the Campaign wave by wave until it completes, is paused or
+// exhausts its FailureBudget. Devices left "upgrading" by an interrupted run
+// are verified again before any new wave is started. Devices refused by
+// upgrade policy are skipped, and deferred ones are retried on the next Run;
+// a Campaign that ends with deferred devices is left CampaignDeferred rather
+// than CampaignComplete.
+func (c *Campaign) Run(uc *UCentral) error {
+	if uc.DryRun {
+		return errors.New("Campaign cannot run in DryRun mode")
+	}
+	err := c.Prepare(uc)
+	if err != nil {
+		return err
+	}
+	if err = c.validate(); err != nil {
+		return err
+	}
+	c.Status = CampaignRunning
+	// devices deferred by policy on a previous run are given another chance
+	for _, cd := range c.inStatus(DeviceDeferred) {
+		cd.Status = DevicePending
+	}
+	c.verifyWave(uc, c.inStatus(DeviceUpgrading))
+	if err = c.checkBudget(); err != nil {
+		return err
+	}
+	for {
+		if c.pauseRequested() {
+			c.Status = CampaignPaused
+			c.Save()
+			return ErrCampaignPaused
+		}
+		batch := c.nextWave()
+		if len(batch) < 1 {
+			c.Status = CampaignComplete
+			if len(c.inStatus(DeviceDeferred)) > 0 {
+				c.Status = CampaignDeferred
+			}
+			return c.Save()
+		}
+		c.Wave++
+		log.Printf("Campaign %s: wave %d upgrading %d device(s)\n", c.Name, c.Wave, len(batch))
+		for _, cd := range batch {
+			c.startUpgrade(uc, cd)
+		}
+		c.Save()
+		c.verifyWave(uc, batch)
+		if err = c.checkBudget(); err != nil {
+			return err
+		}
+	}
+}
+
+// Summary returns a count of devices per status.
+func (c *Campaign) Summary() map[string]int {
+	sum := make(map[string]int)
+	for i := 0; i < len(c.Devices); i++ {
+		sum[c.Devices[i].Status]++
+	}
+	return sum
+}
+
+// GenerateReport returns a list of each CampaignDevice in a printable form.
+func (c *Campaign) GenerateReport() (list []string) {
+	for i := 0; i < len(c.Devices); i++ {
+		cd := c.Devices[i]
+		desc := fmt.Sprintf("%s: %s, ", cd.SerialNumber, cd.Status)
+		desc += fmt.Sprintf("Wave: %d, Revision: %s, ", cd.Wave, cd.Revision)
+		if cd.Error != "" {
+			desc += fmt.Sprintf("Error: %s, ", cd.Error)
+		}
+		list = append(list, desc)
+	}
+	return list
+}
+
+// Report returns a Report with one row per CampaignDevice.
+func (c *Campaign) Report() *Report {
+	r := NewReport(fmt.Sprintf("%s: %s, wave %d", c.Name, c.Status, c.Wave), "serialNumber", "status", "wave", "previousRevision", "revision", "error")
+	for _, cd := range c.Devices {
+		r.Add(cd.SerialNumber, cd.Status, cd.Wave, cd.PreviousRevision, cd.Revision, cd.Error)
+	}
+	return r
+}
+
+func (c *Campaign) inStatus(status string) (list []*CampaignDevice) {
+	for i := 0; i < len(c.Devices); i++ {
+		if c.Devices[i].Status == status {
+			list = append(list, c.Devices[i])
+		}
+	}
+	return list
+}
+
+// nextWave returns the pending devices of the next wave, sized by CanarySize
+// and WaveGrowth relative to the number of waves already run.
+func (c *Campaign) nextWave() []*CampaignDevice {
+	pending := c.inStatus(DevicePending)
+	size := c.CanarySize
+	for w := 0; w < c.Wave && size < len(pending); w++ {
+		if size > len(pending)/c.WaveGrowth {
+			// would outgrow the pending devices, and may overflow
+			size = len(pending)
+			break
+		}
+		size *= c.WaveGrowth
+	}
+	if size > len(pending) {
+		size = len(pending)
+	}
+	return pending[:size]
+}
+
+func (c *Campaign) checkBudget() error {
+	failed := len(c.inStatus(DeviceFailed))
+	if failed > c.FailureBudget {
+		c.Status = CampaignHalted
+		c.Save()
+		return fmt.Errorf("Campaign Halted: %d failure(s) exceeds budget of %d", failed, c.FailureBudget)
+	}
+	return nil
+}
+
+func (c *Campaign) startUpgrade(uc *UCentral, cd *CampaignDevice) {
+	cd.Wave = c.Wave
+	cd.Updated = int(time.Now().Unix())
+	fwd, err := uc.GetFirmwareDevice(cd.SerialNumber)
+	if err != nil {
+		c.fail(cd, err.Error())
+		return
+	}
+	cd.PreviousRevision = fwd.Revision
+	cd.Revision = fwd.Revision
+	if c.TargetRevision != "" && fwd.Revision == c.TargetRevision {
+		cd.Status = DeviceSkipped
+		return
+	}
+	// resolve the full Firmware, as UpgradeDeviceToURI does, so that the
+	// policy sees its Release
+	fw := &Firmware{DeviceType: fwd.DeviceType, Revision: c.TargetRevision, URI: c.TargetURI}
+	fws, err := uc.GetFirmwareListByDevice(fwd.DeviceType)
+	if err == nil {
+		if match := fws.FindByURI(c.TargetURI); match != nil {
+			fw = match
+		}
+	}
+	err = uc.CheckUpgradePolicy(cd.SerialNumber, fw)
+	if _, refused := err.(*PolicyError); refused {
+		cd.Status = DeviceSkipped
+		if IsPolicyDeferred(err) {
+			cd.Status = DeviceDeferred
+		}
+		cd.Error = err.Error()
+		return
+	}
+	if err != nil {
+		c.fail(cd, err.Error())
+		return
+	}
+	err = uc.UpgradeDeviceFirmware(cd.SerialNumber, c.TargetURI)
+	if err != nil {
+		c.fail(cd, err.Error())
+		return
+	}
+	cd.Status = DeviceUpgrading
+}
+
+// verifyWave polls the FMS until every upgrading device of the batch has
+// reconnected on the target revision, or the ReconnectTimeout has passed.
+// The device list is fetched once per poll rather than once per device.
+func (c *Campaign) verifyWave(uc *UCentral, batch []*CampaignDevice) {
+	timeout := time.Duration(c.ReconnectTimeout) * time.Second
+	interval := time.Duration(c.PollInterval) * time.Second
+	done := pollUntil(timeout, interval, func() bool {
+		fwds, err := uc.GetAllFirmwareDevices()
+		if err != nil {
+			return false
+		}
+		bySN := make(map[string]*FirmwareDevice)
+		for i := 0; i < len(fwds.Entry); i++ {
+			bySN[fwds.Entry[i].SerialNumber] = fwds.Entry[i]
+		}
+		waiting := 0
+		for _, cd := range batch {
+			if cd.Status != DeviceUpgrading {
+				continue
+			}
+			fwd, ok := bySN[cd.SerialNumber]
+			if !ok || !fwd.IsConnected() || !c.onTarget(cd, fwd.Revision) {
+				waiting++
+				continue
+			}
+			cd.Revision = fwd.Revision
+			cd.Updated = int(time.Now().Unix())
+			cd.Status = DeviceDone
+			if c.MinSanity > 0 {
+				hc, err := uc.GetDeviceHealthCheck(cd.SerialNumber)
+				if err != nil {
+					c.fail(cd, err.Error())
+				} else if hc.Sanity < c.MinSanity {
+					c.fail(cd, fmt.Sprintf("Sanity %d below %d", hc.Sanity, c.MinSanity))
+				}
+			}
+		}
+		c.Save()
+		return waiting == 0
+	})
+	if done {
+		return
+	}
+	for _, cd := range batch {
+		if cd.Status == DeviceUpgrading {
+			c.fail(cd, "Timed out waiting for reconnect on target revision")
+		}
+	}
+	c.Save()
+}
+
+// fail marks the device failed and alerts on it.
+func (c *Campaign) fail(cd *CampaignDevice, msg string) {
+	cd.Status = DeviceFailed
+	cd.Error = msg
+	if c.Alerts == nil {
+		return
+	}
+	to := c.TargetRevision
+	if to == "" {
+		to = c.TargetURI
+	}
+	err := c.Alerts.Send(UpgradeFailedEvent(cd.SerialNumber, c.DeviceType, cd.PreviousRevision, to))
+	if err != nil {
+		log.Printf("Campaign %s: alert on %s: %s\n", c.Name, cd.SerialNumber, err)
+	}
+}
+
+func (c *Campaign) onTarget(cd *CampaignDevice, revision string) bool {
+	if c.TargetRevision != "" {
+		return revision == c.TargetRevision
+	}
+	return revision != cd.PreviousRevision
+}
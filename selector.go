@@ -0,0 +1,287 @@
+package tipWifi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// SelectorKeys lists the device fields a Selector term can match on.
+var SelectorKeys = []string{"serial", "deviceType", "firmware", "revision", "manufacturer", "venue", "owner", "location", "name", "notes", "connected"}
+
+// The Selector object chooses a set of devices. It is parsed from terms separated
+// by whitespace, all of which a device must match:
+//
+//	aabbccddeeff,112233*     serial numbers or globs, any of which may match
+//	@devices.txt             serial numbers or globs read from a file, one per line
+//	deviceType=edgecore_*    a field matching any of the comma separated globs
+//	venue!=lab               a field matching none of the globs
+//	notes~rma                a field containing any of the comma separated strings
+//	notes~"sent for rma"     double quotes keep whitespace within a term
+//	connected=true           the FMS connection status
+//
+// Matching is case insensitive.
+type Selector struct {
+	Text  string
+	Terms []*SelectorTerm
+}
+
+// The SelectorTerm object is a single condition of a Selector.
+type SelectorTerm struct {
+	Key    string
+	Op     string // "=", "!=" or "~"
+	Values []string
+}
+
+// IsSerialNumber reports whether s is a single, literal Serial Number rather than a selector.
+func IsSerialNumber(s string) bool {
+	return len(s) == 12 && !strings.ContainsAny(s, "*?[]=~,@ ")
+}
+
+// ParseSelector parses the selector language described on the Selector object.
+func ParseSelector(s string) (*Selector, error) {
+	sel := &Selector{Text: s}
+	fields, err := splitSelector(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		term, err := parseSelectorTerm(field)
+		if err != nil {
+			return nil, err
+		}
+		sel.Terms = append(sel.Terms, term)
+	}
+	if len(sel.Terms) < 1 {
+		return nil, errors.New("Empty Selector")
+	}
+	return sel, nil
+}
+
+// splitSelector splits the selector into its whitespace separated terms,
+// keeping whitespace between double quotes and dropping the quotes.
+func splitSelector(s string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	quoted, inField := false, false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(c)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%s :Unterminated Quote in Selector", s)
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+func parseSelectorTerm(field string) (*SelectorTerm, error) {
+	if strings.HasPrefix(field, "@") {
+		data, err := ioutil.ReadFile(field[1:])
+		if err != nil {
+			return nil, err
+		}
+		term := &SelectorTerm{Key: "serial", Op: "="}
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			term.Values = append(term.Values, strings.Fields(line)...)
+		}
+		if len(term.Values) < 1 {
+			return nil, fmt.Errorf("%s :No Serial Numbers in file", field[1:])
+		}
+		return term, nil
+	}
+	term := &SelectorTerm{Key: "serial", Op: "="}
+	value := field
+	if i := strings.IndexAny(field, "!=~"); i > 0 {
+		term.Key, term.Op = field[:i], field[i:i+1]
+		if strings.HasPrefix(field[i:], "!=") {
+			term.Op = "!="
+		} else if term.Op == "!" {
+			return nil, fmt.Errorf("%s :Invalid Selector Operator", field)
+		}
+		value = field[i+len(term.Op):]
+	}
+	if !existsInList(term.Key, SelectorKeys) {
+		return nil, fmt.Errorf("%s :Unknown Selector Key, must be one of %v", term.Key, SelectorKeys)
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v != "" {
+			term.Values = append(term.Values, v)
+		}
+	}
+	if len(term.Values) < 1 {
+		return nil, fmt.Errorf("%s :Selector Term has no value", field)
+	}
+	if strings.EqualFold(term.Key, "connected") {
+		for i, v := range term.Values {
+			v = strings.ToLower(v)
+			term.Values[i] = v
+			if v != "true" && v != "false" {
+				return nil, fmt.Errorf("%s :connected must be true or false", field)
+			}
+		}
+	}
+	return term, nil
+}
+
+// needsFMS reports whether any term matches on a field only known to the FMS.
+func (sel *Selector) needsFMS() bool {
+	for _, term := range sel.Terms {
+		if existsInList(term.Key, []string{"revision", "connected"}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the SelectedDevice satisfies every term of the Selector.
+func (sel *Selector) Matches(sd *SelectedDevice) bool {
+	for _, term := range sel.Terms {
+		if !term.Matches(sd.Field(term.Key)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches returns true if any of the field values satisfies the term, or for
+// "!=" if none of them match.
+func (term *SelectorTerm) Matches(fields []string) bool {
+	for _, f := range fields {
+		f = strings.ToLower(f)
+		for _, v := range term.Values {
+			v = strings.ToLower(v)
+			if term.Op == "~" {
+				if strings.Contains(f, v) {
+					return true
+				}
+				continue
+			}
+			if ok, _ := path.Match(v, f); ok {
+				return term.Op == "="
+			}
+		}
+	}
+	return term.Op == "!="
+}
+
+// The SelectedDevice object joins the GW Device with its FMS status, which is
+// nil when the FMS was not queried or does not know the device.
+type SelectedDevice struct {
+	Device *Device
+	Status *FirmwareDevice
+}
+
+// Field returns the values of a SelectorKey for the device.
+func (sd *SelectedDevice) Field(key string) []string {
+	dev := sd.Device
+	switch strings.ToLower(key) {
+	case "serial":
+		return []string{dev.SerialNumber}
+	case "devicetype":
+		return []string{dev.DeviceType}
+	case "firmware":
+		return []string{dev.Firmware}
+	case "revision":
+		if sd.Status != nil {
+			return []string{sd.Status.Revision}
+		}
+		return []string{""}
+	case "manufacturer":
+		return []string{dev.Manufacturer}
+	case "venue":
+		return []string{dev.Venue}
+	case "owner":
+		return []string{dev.Owner}
+	case "location":
+		return []string{dev.Location, dev.Configuration.Unit.Location}
+	case "name":
+		return []string{dev.Configuration.Unit.Name}
+	case "notes":
+		var list []string
+		for i := 0; i < len(dev.Notes); i++ {
+			list = append(list, dev.Notes[i].Note)
+		}
+		return list
+	case "connected":
+		return []string{fmt.Sprint(sd.Status != nil && sd.Status.IsConnected())}
+	}
+	return nil
+}
+
+// The Selection object is the set of devices matched by a Selector.
+type Selection struct {
+	Selector *Selector
+	Entry    []*SelectedDevice
+}
+
+// Select resolves the Selector against ListDevices, and GetAllFirmwareDevices
+// when the connection status or FMS revision is needed.
+func (uc *UCentral) Select(sel *Selector) (*Selection, error) {
+	devs, err := uc.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	status := make(map[string]*FirmwareDevice)
+	if sel.needsFMS() {
+		fwds, err := uc.GetAllFirmwareDevices()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(fwds.Entry); i++ {
+			status[fwds.Entry[i].SerialNumber] = fwds.Entry[i]
+		}
+	}
+	s := &Selection{Selector: sel}
+	for i := 0; i < len(devs.Entry); i++ {
+		sd := &SelectedDevice{
+			Device: devs.Entry[i],
+			Status: status[devs.Entry[i].SerialNumber],
+		}
+		if sel.Matches(sd) {
+			s.Entry = append(s.Entry, sd)
+		}
+	}
+	return s, nil
+}
+
+// SerialNumbers returns the Serial Number of each selected device.
+func (s *Selection) SerialNumbers() (list []string) {
+	for i := 0; i < len(s.Entry); i++ {
+		list = append(list, s.Entry[i].Device.SerialNumber)
+	}
+	return list
+}
+
+// Report returns a preview of the selected devices.
+func (s *Selection) Report() *Report {
+	r := NewReport(fmt.Sprintf("%q matched %d device(s)", s.Selector.Text, len(s.Entry)), "serialNumber", "deviceType", "firmware", "connected", "venue", "owner", "name", "location")
+	for _, sd := range s.Entry {
+		var connected interface{}
+		if sd.Status != nil {
+			connected = sd.Status.IsConnected()
+		}
+		dev := sd.Device
+		r.Add(dev.SerialNumber, dev.DeviceType, dev.Firmware, connected, dev.Venue, dev.Owner, dev.Configuration.Unit.Name, dev.Configuration.Unit.Location)
+	}
+	return r
+}
@@ -0,0 +1,75 @@
+package tipWifi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in    string
+		terms []*SelectorTerm
+		err   bool
+	}{
+		{"aabbccddeeff", []*SelectorTerm{{Key: "serial", Op: "=", Values: []string{"aabbccddeeff"}}}, false},
+		{"aabbccddeeff,112233*", []*SelectorTerm{{Key: "serial", Op: "=", Values: []string{"aabbccddeeff", "112233*"}}}, false},
+		{"deviceType=edgecore_*", []*SelectorTerm{{Key: "deviceType", Op: "=", Values: []string{"edgecore_*"}}}, false},
+		{"venue!=lab", []*SelectorTerm{{Key: "venue", Op: "!=", Values: []string{"lab"}}}, false},
+		{"notes~rma", []*SelectorTerm{{Key: "notes", Op: "~", Values: []string{"rma"}}}, false},
+		{"deviceType=a,b connected=true", []*SelectorTerm{
+			{Key: "deviceType", Op: "=", Values: []string{"a", "b"}},
+			{Key: "connected", Op: "=", Values: []string{"true"}},
+		}, false},
+		{`notes~"sent for rma" connected=TRUE`, []*SelectorTerm{
+			{Key: "notes", Op: "~", Values: []string{"sent for rma"}},
+			{Key: "connected", Op: "=", Values: []string{"true"}},
+		}, false},
+		{"", nil, true},
+		{`notes~"rma`, nil, true},
+		{"colour=red", nil, true},
+		{"venue=", nil, true},
+		{"venue!lab", nil, true},
+		{"connected=maybe", nil, true},
+		{"@/nonexistent/devices.txt", nil, true},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseSelector(%q) = %v, want error", tt.in, sel.Terms)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %s", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(sel.Terms, tt.terms) {
+			t.Errorf("ParseSelector(%q) = %+v, want %+v", tt.in, sel.Terms, tt.terms)
+		}
+	}
+}
+
+func TestSelectorTermMatches(t *testing.T) {
+	tests := []struct {
+		term   string
+		fields []string
+		want   bool
+	}{
+		{"aabbcc*", []string{"aabbccddeeff"}, true},
+		{"aabbcc*", []string{"112233445566"}, false},
+		{"venue!=lab", []string{"office"}, true},
+		{"venue!=lab", []string{"lab"}, false},
+		{"notes~rma", []string{"sent for RMA"}, true},
+		{"deviceType=edgecore_*", []string{"edgecore_eap101"}, true},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.term)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): %s", tt.term, err)
+		}
+		if got := sel.Terms[0].Matches(tt.fields); got != tt.want {
+			t.Errorf("%q.Matches(%v) = %t, want %t", tt.term, tt.fields, got, tt.want)
+		}
+	}
+}
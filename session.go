@@ -0,0 +1,136 @@
+package tipWifi
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrSessionExpired is returned by RestoreSession when the cached token can no longer be used.
+var ErrSessionExpired = errors.New("Session Expired")
+
+// The Session object is the cached state of an authenticated UCentral: the
+// OAuth2 token and the endpoints discovered with it. LastUsed tracks the
+// token's idle timeout between invocations.
+type Session struct {
+	SEC      string  `json:"sec"`
+	GW       string  `json:"gw"`
+	FMS      string  `json:"fms"`
+	OAuth2   *OAuth2 `json:"oauth2"`
+	LastUsed int     `json:"lastUsed"`
+}
+
+// ExpiresAt returns when the OAuth2 token expires, or the zero time if unknown.
+func (o *OAuth2) ExpiresAt() time.Time {
+	if o.Created == 0 || o.ExpiresIn == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(o.Created+o.ExpiresIn), 0)
+}
+
+// Valid returns whether the OAuth2 token is usable at the supplied time, given
+// when it was last used. A safety margin of a minute is kept before expiry.
+func (o *OAuth2) Valid(now time.Time, lastUsed int) bool {
+	if o == nil || o.AccessToken == "" {
+		return false
+	}
+	margin := time.Minute
+	if exp := o.ExpiresAt(); !exp.IsZero() && now.Add(margin).After(exp) {
+		return false
+	}
+	if o.IdleTimeout > 0 && lastUsed > 0 {
+		idle := time.Unix(int64(lastUsed+o.IdleTimeout), 0)
+		if now.Add(margin).After(idle) {
+			return false
+		}
+	}
+	return true
+}
+
+// SaveSession writes the token and endpoints of the UCentral to the supplied file,
+// readable only by the current user.
+func (uc *UCentral) SaveSession(file string) error {
+	if uc.OAuth2 == nil || uc.OAuth2.AccessToken == "" {
+		return errors.New("Must authenticate first")
+	}
+	s := &Session{
+		SEC:      uc.SEC,
+		GW:       uc.GW,
+		FMS:      uc.FMS,
+		OAuth2:   uc.OAuth2,
+		LastUsed: int(time.Now().Unix()),
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// LoadSession reads a Session from the supplied file. Files that are readable by
+// group or others are refused, since they contain a bearer token.
+func LoadSession(file string) (*Session, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return nil, errors.New("Session file must not be accessible by group or others")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RestoreSession loads the cached Session into the UCentral if it belongs to the
+// same SEC endpoint and user and its token is still valid, and marks it as used.
+func (uc *UCentral) RestoreSession(file string) error {
+	s, err := LoadSession(file)
+	if err != nil {
+		return err
+	}
+	if uc.SEC != "" && s.SEC != uc.SEC {
+		return errors.New("Session belongs to a different Security Endpoint")
+	}
+	if !s.OAuth2.Valid(time.Now(), s.LastUsed) {
+		return ErrSessionExpired
+	}
+	// a login of another user is as unusable as an expired one
+	if uc.Auth != nil && uc.Auth.UserID != "" && !strings.EqualFold(s.OAuth2.Username, uc.Auth.UserID) {
+		return ErrSessionExpired
+	}
+	uc.SEC = s.SEC
+	uc.GW = s.GW
+	uc.FMS = s.FMS
+	uc.OAuth2 = s.OAuth2
+	return uc.SaveSession(file)
+}
+
+// RemoveSession deletes a cached Session file.
+func RemoveSession(file string) error {
+	err := os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
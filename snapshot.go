@@ -0,0 +1,217 @@
+package tipWifi
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Transition fields reported by Snapshot.Diff.
+const (
+	FieldStatus   = "status"
+	FieldRevision = "revision"
+	FieldConfig   = "config"
+	FieldAdded    = "added"
+	FieldRemoved  = "removed"
+)
+
+// The Snapshot object records the state of a set of devices at one point in time,
+// so that consecutive snapshots can be compared for transitions.
+type Snapshot struct {
+	Taken   time.Time
+	Devices map[string]*DeviceState
+}
+
+// The DeviceState object is the part of a device's GW and FMS records that is
+// tracked between snapshots.
+type DeviceState struct {
+	SerialNumber string
+	DeviceType   string
+	Connected    bool
+	Revision     string
+	ConfigUUID   int
+	ConfigLag    time.Duration // see ConfigLag, not compared between snapshots
+	LastChange   time.Time     // time of the last Transition, zero if none was seen
+	Unknown      bool          // the FMS could not be queried, Connected and Revision are carried over by Diff
+}
+
+// The Transition object describes a change of one field of a device between snapshots.
+type Transition struct {
+	SerialNumber string
+	Field        string
+	From         string
+	To           string
+	At           time.Time
+}
+
+// GenerateDescription returns a string of concatenated values describing the Transition object.
+func (t *Transition) GenerateDescription() string {
+	desc := fmt.Sprintf("%s %s: ", t.At.Format("15:04:05"), t.SerialNumber)
+	switch t.Field {
+	case FieldAdded, FieldRemoved:
+		desc += t.Field
+	default:
+		desc += fmt.Sprintf("%s %s -> %s", t.Field, t.From, t.To)
+	}
+	return desc
+}
+
+// TakeSnapshot records the state of the supplied devices, or of every device
+// when none are supplied, from the FMS status and the GW configuration UUID.
+func (uc *UCentral) TakeSnapshot(sns ...string) (*Snapshot, error) {
+	s := &Snapshot{
+		Taken:   time.Now(),
+		Devices: make(map[string]*DeviceState),
+	}
+	var devs []*Device
+	var fwds []*FirmwareDevice
+	if len(sns) < 1 {
+		all, err := uc.ListDevices()
+		if err != nil {
+			return nil, err
+		}
+		devs = all.Entry
+		allfw, err := uc.GetAllFirmwareDevices()
+		if err != nil {
+			return nil, err
+		}
+		fwds = allfw.Entry
+	}
+	unknown := make(map[string]bool)
+	for _, sn := range sns {
+		dev, err := uc.GetDevice(sn)
+		if err != nil {
+			return nil, err
+		}
+		devs = append(devs, dev)
+		fwd, err := uc.GetFirmwareDevice(sn)
+		if err != nil {
+			unknown[dev.SerialNumber] = true
+			continue
+		}
+		fwds = append(fwds, fwd)
+	}
+	for _, dev := range devs {
+		if unknown[dev.SerialNumber] {
+			s.Devices[dev.SerialNumber] = &DeviceState{
+				SerialNumber: dev.SerialNumber,
+				DeviceType:   dev.DeviceType,
+				ConfigUUID:   dev.UUID,
+				ConfigLag:    ConfigLag(dev, s.Taken),
+				Unknown:      true,
+			}
+			continue
+		}
+		s.Devices[dev.SerialNumber] = &DeviceState{
+			SerialNumber: dev.SerialNumber,
+			DeviceType:   dev.DeviceType,
+			Revision:     dev.Firmware,
+			ConfigUUID:   dev.UUID,
+			ConfigLag:    ConfigLag(dev, s.Taken),
+		}
+	}
+	for _, fwd := range fwds {
+		ds, ok := s.Devices[fwd.SerialNumber]
+		if !ok {
+			continue
+		}
+		ds.Connected = fwd.IsConnected()
+		if fwd.Revision != "" {
+			ds.Revision = fwd.Revision
+		}
+	}
+	return s, nil
+}
+
+// Diff returns the transitions from the previous snapshot to this one, sorted by
+// Serial Number, and carries the LastChange of each device forward, along with
+// the last known status and revision of a device whose state is Unknown. A nil
+// previous snapshot yields no transitions.
+func (s *Snapshot) Diff(prev *Snapshot) (list []*Transition) {
+	if prev == nil {
+		return nil
+	}
+	add := func(sn, field, from, to string) {
+		list = append(list, &Transition{SerialNumber: sn, Field: field, From: from, To: to, At: s.Taken})
+	}
+	for sn, ds := range s.Devices {
+		old, ok := prev.Devices[sn]
+		if !ok {
+			add(sn, FieldAdded, "", "")
+			ds.LastChange = s.Taken
+			continue
+		}
+		ds.LastChange = old.LastChange
+		n := len(list)
+		if ds.Unknown {
+			ds.Connected, ds.Revision = old.Connected, old.Revision
+		}
+		// nothing is known to compare with until the FMS has answered once
+		known := !old.Unknown || old.Revision != ""
+		if known && ds.Connected != old.Connected {
+			add(sn, FieldStatus, upDown(old.Connected), upDown(ds.Connected))
+		}
+		if known && ds.Revision != old.Revision {
+			add(sn, FieldRevision, old.Revision, ds.Revision)
+		}
+		if ds.ConfigUUID != old.ConfigUUID {
+			add(sn, FieldConfig, strconv.Itoa(old.ConfigUUID), strconv.Itoa(ds.ConfigUUID))
+		}
+		if len(list) > n {
+			ds.LastChange = s.Taken
+		}
+	}
+	for sn := range prev.Devices {
+		if _, ok := s.Devices[sn]; !ok {
+			add(sn, FieldRemoved, "", "")
+		}
+	}
+	sort.SliceStable(list, func(a, b int) bool {
+		return list[a].SerialNumber < list[b].SerialNumber
+	})
+	return list
+}
+
+// SerialNumbers returns the Serial Numbers in the Snapshot in sorted order.
+func (s *Snapshot) SerialNumbers() (list []string) {
+	for sn := range s.Devices {
+		list = append(list, sn)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Report returns a Report with the state of each device in the Snapshot.
+func (s *Snapshot) Report() *Report {
+	r := NewReport(s.Taken.Format(time.RFC1123), "serialNumber", "deviceType", "status", "revision", "configUUID", "lastChange")
+	for _, sn := range s.SerialNumbers() {
+		ds := s.Devices[sn]
+		last := ""
+		if !ds.LastChange.IsZero() {
+			last = ds.LastChange.Format("15:04:05")
+		}
+		status := upDown(ds.Connected)
+		if ds.Unknown {
+			status = "UNKNOWN"
+		}
+		r.Add(ds.SerialNumber, ds.DeviceType, status, ds.Revision, ds.ConfigUUID, last)
+	}
+	return r
+}
+
+// TransitionReport returns a Report with one row per Transition.
+func TransitionReport(list []*Transition) *Report {
+	r := NewReport("Transitions", "at", "serialNumber", "field", "from", "to")
+	for _, t := range list {
+		r.Add(t.At.Format(time.RFC3339), t.SerialNumber, t.Field, t.From, t.To)
+	}
+	return r
+}
+
+func upDown(connected bool) string {
+	if connected {
+		return "UP"
+	}
+	return "DOWN"
+}
@@ -0,0 +1,100 @@
+package tipWifi
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The DeviceStatistics object is the most recent state message a device sent
+// to the GW, reduced to the unit, radio and interface counters of interest.
+type DeviceStatistics struct {
+	Unit struct {
+		Load   []float64 `json:"load"`
+		Uptime int       `json:"uptime"` // seconds since the device booted
+		Memory struct {
+			Total int `json:"total"`
+			Free  int `json:"free"`
+		} `json:"memory"`
+		Localtime int `json:"localtime"`
+	} `json:"unit"`
+	Radios     []*RadioStatistics     `json:"radios"`
+	Interfaces []*InterfaceStatistics `json:"interfaces"`
+}
+
+// The RadioStatistics object describes the operating state of one radio phy.
+type RadioStatistics struct {
+	Phy     string `json:"phy"`
+	Channel int    `json:"channel"`
+	Noise   int    `json:"noise"`
+	TxPower int    `json:"tx_power"`
+}
+
+// The InterfaceStatistics object describes one logical interface and the SSIDs
+// it is broadcasting.
+type InterfaceStatistics struct {
+	Name     string            `json:"name"`
+	Location string            `json:"location"`
+	Uptime   int               `json:"uptime"`
+	SSIDs    []*SSIDStatistics `json:"ssids"`
+}
+
+// The SSIDStatistics object describes a BSS and its associated clients. Radio
+// references the entry of DeviceStatistics.Radios it runs on, ex: "#/radios/0".
+type SSIDStatistics struct {
+	SSID  string `json:"ssid"`
+	BSSID string `json:"bssid"`
+	Mode  string `json:"mode"`
+	Phy   string `json:"phy"`
+	Iface string `json:"iface"`
+	Radio struct {
+		Ref string `json:"$ref"`
+	} `json:"radio"`
+	Associations []*Association `json:"associations"`
+}
+
+// The Association object describes a client station associated to a BSS.
+type Association struct {
+	Station string `json:"station"`
+	BSSID   string `json:"bssid"`
+	RSSI    int    `json:"rssi"`
+	RxBytes int64  `json:"rx_bytes"`
+	TxBytes int64  `json:"tx_bytes"`
+}
+
+// ClientsPerRadio returns the number of associated clients on each entry of
+// Radios. An SSID without a radio reference is matched to a radio by its phy.
+func (ds *DeviceStatistics) ClientsPerRadio() []int {
+	counts := make([]int, len(ds.Radios))
+	for _, iface := range ds.Interfaces {
+		for _, ssid := range iface.SSIDs {
+			n := ds.radioIndex(ssid)
+			if n >= 0 {
+				counts[n] += len(ssid.Associations)
+			}
+		}
+	}
+	return counts
+}
+
+// Clients returns the total number of associated clients.
+func (ds *DeviceStatistics) Clients() (n int) {
+	for _, c := range ds.ClientsPerRadio() {
+		n += c
+	}
+	return n
+}
+
+func (ds *DeviceStatistics) radioIndex(ssid *SSIDStatistics) int {
+	if strings.HasPrefix(ssid.Radio.Ref, "#/radios/") {
+		n, err := strconv.Atoi(strings.TrimPrefix(ssid.Radio.Ref, "#/radios/"))
+		if err == nil && n >= 0 && n < len(ds.Radios) {
+			return n
+		}
+	}
+	for i := 0; i < len(ds.Radios); i++ {
+		if ssid.Phy != "" && ds.Radios[i].Phy == ssid.Phy {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,450 @@
+package tipWifi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of Record kept in a Store.
+const (
+	RecordStatus = "status" // connection status of a device at a poll
+	RecordStats  = "stats"  // values taken from the DeviceStatistics and HealthCheck
+	RecordEvent  = "event"  // an Event emitted by a Monitor
+)
+
+// Metrics recorded in the Values of a RecordStats Record.
+const (
+	MetricUptime     = "uptime"      // seconds since boot
+	MetricClients    = "clients"     // associated clients on every radio
+	MetricLoad       = "load"        // 1 minute load average
+	MetricMemoryFree = "memory_free" // bytes
+	MetricSanity     = "sanity"      // of the most recent HealthCheck, 0-100
+	MetricScore      = "score"       // HealthScore without reboots or firmware staleness
+)
+
+// Metrics lists every metric a Monitor records.
+var Metrics = []string{MetricUptime, MetricClients, MetricLoad, MetricMemoryFree, MetricSanity, MetricScore}
+
+// DefaultStatsInterval is how often a Monitor records statistics to its Store
+// when no StatsInterval is set.
+const DefaultStatsInterval = 15 * time.Minute
+
+// storeMaxLine bounds the length of a single Record in the Store files.
+const storeMaxLine = 1 << 20
+
+// storeDay is the layout of the name of a Store file, one per UTC day.
+const storeDay = "2006-01-02"
+
+// The Record object is a single entry of a Store.
+type Record struct {
+	Time         time.Time          `json:"time"`
+	SerialNumber string             `json:"serialNumber"`
+	Kind         string             `json:"kind"`
+	Connected    bool               `json:"connected,omitempty"`
+	Values       map[string]float64 `json:"values,omitempty"`
+	Event        *Event             `json:"event,omitempty"`
+}
+
+// The Store object is a local history of device status, statistics, health
+// and events kept beyond the window the controller retains them for. Records
+// are appended as JSON lines to one file per UTC day in the Path directory,
+// so the files can be read by other tools, queries read only the days they
+// cover and old history is removed a day at a time. Each Write opens and
+// closes its files, so several processes may share a Store.
+type Store struct {
+	Path string
+
+	mu      sync.Mutex
+	skipped int64
+}
+
+// OpenStore returns the Store in the supplied directory, creating it when missing.
+func OpenStore(path string) (*Store, error) {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Path: path}, nil
+}
+
+func (st *Store) dayFile(t time.Time) string {
+	return filepath.Join(st.Path, t.UTC().Format(storeDay)+".jsonl")
+}
+
+// Write appends the Records to the files of the days they were taken on.
+func (st *Store) Write(recs ...*Record) error {
+	days := make(map[string][]byte)
+	var order []string
+	for _, rec := range recs {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		file := st.dayFile(rec.Time)
+		if _, ok := days[file]; !ok {
+			order = append(order, file)
+		}
+		days[file] = append(append(days[file], data...), '\n')
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, file := range order {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+		data := days[file]
+		if !endsLine(f) {
+			// a Record cut short by a crash, keep it off the line of this one
+			data = append([]byte{'\n'}, data...)
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// days returns the day files of the Store overlapping the two times, oldest
+// first, with the start of the day each covers.
+func (st *Store) days(from, to time.Time) (files []string, starts []time.Time, err error) {
+	list, err := ioutil.ReadDir(st.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, fi := range list {
+		name := fi.Name()
+		if fi.IsDir() || filepath.Ext(name) != ".jsonl" {
+			continue
+		}
+		day, err := time.Parse(storeDay, strings.TrimSuffix(name, ".jsonl"))
+		if err != nil {
+			continue
+		}
+		if (!from.IsZero() && !day.AddDate(0, 0, 1).After(from)) || (!to.IsZero() && day.After(to)) {
+			continue
+		}
+		files = append(files, filepath.Join(st.Path, name))
+		starts = append(starts, day)
+	}
+	// ReadDir sorts by name, which orders the days
+	return files, starts, nil
+}
+
+// scan calls fn for every Record of the Store between the two times, reading
+// only the files of the days they cover.
+func (st *Store) scan(from, to time.Time, fn func(rec *Record) error) error {
+	files, _, err := st.days(from, to)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = st.scanFile(file, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanFile calls fn for every Record of the file in the order written. A line
+// that does not parse is skipped and counted, as it is a Record being written
+// or one cut short by a crash.
+func (st *Store) scanFile(file string, fn func(rec *Record) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), storeMaxLine)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		rec := &Record{}
+		if json.Unmarshal(sc.Bytes(), rec) != nil {
+			atomic.AddInt64(&st.skipped, 1)
+			continue
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// endsLine reports whether the file is empty or its last byte ends a line.
+func endsLine(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return true
+	}
+	b := make([]byte, 1)
+	if _, err = f.ReadAt(b, fi.Size()-1); err != nil {
+		return true
+	}
+	return b[0] == '\n'
+}
+
+// Skipped returns the number of lines the queries of the Store could not parse.
+func (st *Store) Skipped() int {
+	return int(atomic.LoadInt64(&st.skipped))
+}
+
+// Query returns the Records of the device and kind between the two times,
+// oldest first. An empty Serial Number or kind matches every one, and a zero
+// time leaves that end of the range open.
+func (st *Store) Query(sn, kind string, from, to time.Time) ([]*Record, error) {
+	var list []*Record
+	err := st.scan(from, to, func(rec *Record) error {
+		if (sn == "" || rec.SerialNumber == sn) && (kind == "" || rec.Kind == kind) && inRange(rec.Time, from, to) {
+			list = append(list, rec)
+		}
+		return nil
+	})
+	sort.SliceStable(list, func(a, b int) bool {
+		return list[a].Time.Before(list[b].Time)
+	})
+	return list, err
+}
+
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+}
+
+// HistoryReport returns a Report of every Record of the device between the two times.
+func (st *Store) HistoryReport(sn string, from, to time.Time) (*Report, error) {
+	list, err := st.Query(sn, "", from, to)
+	if err != nil {
+		return nil, err
+	}
+	columns := append([]string{"time", "kind", "status", "event"}, Metrics...)
+	r := NewReport(fmt.Sprintf("%s: %d record(s)", sn, len(list)), columns...)
+	for _, rec := range list {
+		row := []interface{}{rec.Time.Format(time.RFC3339), rec.Kind, "", ""}
+		switch rec.Kind {
+		case RecordStatus:
+			row[2] = upDown(rec.Connected)
+		case RecordEvent:
+			if rec.Event != nil {
+				row[3] = fmt.Sprintf("%s %s -> %s", rec.Event.Type, rec.Event.From, rec.Event.To)
+			}
+		}
+		for _, m := range Metrics {
+			if v, ok := rec.Values[m]; ok {
+				row = append(row, v)
+			} else {
+				row = append(row, "")
+			}
+		}
+		r.Add(row...)
+	}
+	return r, nil
+}
+
+// The Availability object is the share of time a device was connected over
+// the time covered by its status Records. Each Record holds until the next
+// one, unless the gap is too long to tell what happened in between.
+type Availability struct {
+	SerialNumber string
+	From         time.Time
+	To           time.Time
+	Connected    time.Duration
+	Covered      time.Duration
+	Samples      int
+}
+
+// Percent returns the connected share of the covered time, or -1 without coverage.
+func (a *Availability) Percent() float64 {
+	if a.Covered <= 0 {
+		return -1
+	}
+	return 100 * float64(a.Connected) / float64(a.Covered)
+}
+
+// Uptime returns the Availability of the supplied devices, or of every device
+// in the Store when none are supplied, between the two times. Gaps between
+// status Records longer than maxGap, such as while the Monitor was not
+// running, are left out; a maxGap of 0 allows any gap. A Monitor records a
+// status at every change and checkpoint, so maxGap must exceed its
+// StatsInterval.
+func (st *Store) Uptime(from, to time.Time, maxGap time.Duration, sns ...string) ([]*Availability, error) {
+	list, err := st.Query("", RecordStatus, from, to)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, sn := range sns {
+		wanted[sn] = true
+	}
+	byID := make(map[string]*Availability)
+	last := make(map[string]*Record)
+	for _, rec := range list {
+		if len(wanted) > 0 && !wanted[rec.SerialNumber] {
+			continue
+		}
+		a, ok := byID[rec.SerialNumber]
+		if !ok {
+			a = &Availability{SerialNumber: rec.SerialNumber, From: rec.Time}
+			byID[rec.SerialNumber] = a
+		}
+		a.Samples++
+		a.To = rec.Time
+		if prev := last[rec.SerialNumber]; prev != nil {
+			gap := rec.Time.Sub(prev.Time)
+			if maxGap <= 0 || gap <= maxGap {
+				a.Covered += gap
+				if prev.Connected {
+					a.Connected += gap
+				}
+			}
+		}
+		last[rec.SerialNumber] = rec
+	}
+	var out []*Availability
+	for _, a := range byID {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		return out[a].SerialNumber < out[b].SerialNumber
+	})
+	return out, nil
+}
+
+// UptimeReport returns a Report of the Availability of each device, least available first.
+func UptimeReport(list []*Availability) *Report {
+	sorted := append([]*Availability{}, list...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].Percent() < sorted[b].Percent()
+	})
+	r := NewReport("Uptime", "serialNumber", "uptimePercent", "connected", "covered", "samples", "from", "to")
+	for _, a := range sorted {
+		pct := ""
+		if a.Percent() >= 0 {
+			pct = fmt.Sprintf("%.2f", a.Percent())
+		}
+		r.Add(a.SerialNumber, pct, a.Connected.Round(time.Second).String(), a.Covered.Round(time.Second).String(), a.Samples, a.From.Format(time.RFC3339), a.To.Format(time.RFC3339))
+	}
+	return r
+}
+
+// The TrendBucket object summarises the values of a metric within one bucket of a Trend.
+type TrendBucket struct {
+	Start time.Time
+	Count int
+	Min   float64
+	Max   float64
+	Mean  float64
+}
+
+// The Trend object is a metric of a device summarised over consecutive buckets.
+type Trend struct {
+	SerialNumber string
+	Metric       string
+	Bucket       time.Duration
+	Entry        []*TrendBucket // buckets without values are omitted
+}
+
+// Trend summarises a metric of the device between the two times into buckets
+// of the supplied duration, aligned to the start of the range or of the first
+// value when the range is open.
+func (st *Store) Trend(sn, metric string, from, to time.Time, bucket time.Duration) (*Trend, error) {
+	if bucket <= 0 {
+		return nil, errors.New("Bucket Must Be Positive")
+	}
+	list, err := st.Query(sn, RecordStats, from, to)
+	if err != nil {
+		return nil, err
+	}
+	tr := &Trend{SerialNumber: sn, Metric: metric, Bucket: bucket}
+	start := from
+	var cur *TrendBucket
+	var sum float64
+	for _, rec := range list {
+		v, ok := rec.Values[metric]
+		if !ok {
+			continue
+		}
+		if start.IsZero() {
+			start = rec.Time
+		}
+		bstart := start.Add(rec.Time.Sub(start) / bucket * bucket)
+		if cur == nil || !cur.Start.Equal(bstart) {
+			cur = &TrendBucket{Start: bstart, Min: math.Inf(1), Max: math.Inf(-1)}
+			sum = 0
+			tr.Entry = append(tr.Entry, cur)
+		}
+		cur.Count++
+		sum += v
+		cur.Mean = sum / float64(cur.Count)
+		cur.Min = math.Min(cur.Min, v)
+		cur.Max = math.Max(cur.Max, v)
+	}
+	return tr, nil
+}
+
+// Change returns the difference between the mean of the last and first bucket.
+func (tr *Trend) Change() float64 {
+	if len(tr.Entry) < 1 {
+		return 0
+	}
+	return tr.Entry[len(tr.Entry)-1].Mean - tr.Entry[0].Mean
+}
+
+// Report returns a Report with one row per bucket of the Trend.
+func (tr *Trend) Report() *Report {
+	title := fmt.Sprintf("%s %s per %s, change %+.2f", tr.SerialNumber, tr.Metric, tr.Bucket, tr.Change())
+	r := NewReport(title, "start", "count", "min", "mean", "max")
+	for _, b := range tr.Entry {
+		r.Add(b.Start.Format(time.RFC3339), b.Count, b.Min, math.Round(b.Mean*100)/100, b.Max)
+	}
+	return r
+}
+
+// Compact removes the day files of the Store that end before the supplied
+// time, except the current day's, and returns how many Records they held.
+// Files are only ever removed whole, so a Monitor writing to the Store at the
+// same time loses nothing.
+func (st *Store) Compact(before time.Time) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	files, starts, err := st.days(time.Time{}, before)
+	if err != nil {
+		return 0, err
+	}
+	today := time.Now().UTC().Format(storeDay)
+	removed := 0
+	for i, file := range files {
+		if starts[i].AddDate(0, 0, 1).After(before) || starts[i].Format(storeDay) == today {
+			continue
+		}
+		n := 0
+		err = st.scanFile(file, func(rec *Record) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+		if err = os.Remove(file); err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
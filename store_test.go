@@ -0,0 +1,179 @@
+package tipWifi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore returns a Store in a temporary directory holding the Records.
+func testStore(t *testing.T, recs ...*Record) *Store {
+	st, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Write(recs...); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func status(sn string, at time.Time, connected bool) *Record {
+	return &Record{Time: at, SerialNumber: sn, Kind: RecordStatus, Connected: connected}
+}
+
+func stats(sn string, at time.Time, metric string, v float64) *Record {
+	return &Record{Time: at, SerialNumber: sn, Kind: RecordStats, Values: map[string]float64{metric: v}}
+}
+
+func TestStoreUptime(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	at := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Minute) }
+	tests := []struct {
+		name      string
+		recs      []*Record
+		from, to  time.Time
+		maxGap    time.Duration
+		sns       []string
+		want      map[string]float64 // percent per Serial Number
+		connected map[string]time.Duration
+	}{
+		{"always up", []*Record{status("a", at(0), true), status("a", at(30), true)}, time.Time{}, time.Time{}, 0, nil,
+			map[string]float64{"a": 100}, map[string]time.Duration{"a": 30 * time.Minute}},
+		{"down a quarter", []*Record{status("a", at(0), true), status("a", at(45), false), status("a", at(60), true)}, time.Time{}, time.Time{}, 0, nil,
+			map[string]float64{"a": 75}, map[string]time.Duration{"a": 45 * time.Minute}},
+		{"across midnight", []*Record{status("a", at(0), false), status("a", at(90), true), status("a", at(120), true)}, time.Time{}, time.Time{}, 0, nil,
+			map[string]float64{"a": 25}, map[string]time.Duration{"a": 30 * time.Minute}},
+		{"gap left out", []*Record{status("a", at(0), true), status("a", at(10), false), status("a", at(100), false), status("a", at(110), true)}, time.Time{}, time.Time{}, 15 * time.Minute, nil,
+			map[string]float64{"a": 50}, map[string]time.Duration{"a": 10 * time.Minute}},
+		{"range", []*Record{status("a", at(0), false), status("a", at(60), true), status("a", at(90), true)}, at(30), time.Time{}, 0, nil,
+			map[string]float64{"a": 100}, map[string]time.Duration{"a": 30 * time.Minute}},
+		{"selected devices", []*Record{status("a", at(0), true), status("b", at(0), false), status("a", at(10), true), status("b", at(10), false)}, time.Time{}, time.Time{}, 0, []string{"b"},
+			map[string]float64{"b": 0}, map[string]time.Duration{"b": 0}},
+		{"single sample", []*Record{status("a", at(0), true)}, time.Time{}, time.Time{}, 0, nil,
+			map[string]float64{"a": -1}, map[string]time.Duration{"a": 0}},
+	}
+	for _, tt := range tests {
+		st := testStore(t, tt.recs...)
+		list, err := st.Uptime(tt.from, tt.to, tt.maxGap, tt.sns...)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if len(list) != len(tt.want) {
+			t.Errorf("%s: %d device(s), want %d", tt.name, len(list), len(tt.want))
+			continue
+		}
+		for _, a := range list {
+			if a.Percent() != tt.want[a.SerialNumber] || a.Connected != tt.connected[a.SerialNumber] {
+				t.Errorf("%s: %s %.2f%% connected %s, want %.2f%% connected %s", tt.name, a.SerialNumber, a.Percent(), a.Connected, tt.want[a.SerialNumber], tt.connected[a.SerialNumber])
+			}
+		}
+	}
+}
+
+func TestStoreTrend(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h float64) time.Time { return t0.Add(time.Duration(h * float64(time.Hour))) }
+	recs := []*Record{
+		stats("a", hour(0), MetricClients, 10),
+		stats("a", hour(0.5), MetricClients, 20),
+		stats("a", hour(1.25), MetricClients, 30),
+		stats("a", hour(3), MetricClients, 40),
+		stats("a", hour(3.5), MetricLoad, 1),
+		stats("b", hour(0), MetricClients, 99),
+	}
+	tests := []struct {
+		name     string
+		metric   string
+		from, to time.Time
+		bucket   time.Duration
+		starts   []time.Time
+		means    []float64
+		change   float64
+	}{
+		{"hourly", MetricClients, time.Time{}, time.Time{}, time.Hour, []time.Time{hour(0), hour(1), hour(3)}, []float64{15, 30, 40}, 25},
+		{"two hours", MetricClients, time.Time{}, time.Time{}, 2 * time.Hour, []time.Time{hour(0), hour(2)}, []float64{20, 40}, 20},
+		{"aligned to from", MetricClients, hour(0.25), hour(2), time.Hour, []time.Time{hour(0.25), hour(1.25)}, []float64{20, 30}, 10},
+		{"other metric", MetricLoad, time.Time{}, time.Time{}, time.Hour, []time.Time{hour(3.5)}, []float64{1}, 0},
+		{"no values", MetricSanity, time.Time{}, time.Time{}, time.Hour, nil, nil, 0},
+	}
+	st := testStore(t, recs...)
+	for _, tt := range tests {
+		tr, err := st.Trend("a", tt.metric, tt.from, tt.to, tt.bucket)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if len(tr.Entry) != len(tt.starts) {
+			t.Errorf("%s: %d bucket(s), want %d", tt.name, len(tr.Entry), len(tt.starts))
+			continue
+		}
+		for i, b := range tr.Entry {
+			if !b.Start.Equal(tt.starts[i]) || b.Mean != tt.means[i] {
+				t.Errorf("%s: bucket %d starts %s mean %.2f, want %s mean %.2f", tt.name, i, b.Start, b.Mean, tt.starts[i], tt.means[i])
+			}
+		}
+		if tr.Change() != tt.change {
+			t.Errorf("%s: Change() = %.2f, want %.2f", tt.name, tr.Change(), tt.change)
+		}
+	}
+	if _, err := st.Trend("a", MetricClients, time.Time{}, time.Time{}, 0); err == nil {
+		t.Error("Trend with a zero bucket, want error")
+	}
+}
+
+func TestStoreTornLine(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := testStore(t, status("a", t0, true), status("a", t0.Add(time.Minute), false))
+	f, err := os.OpenFile(filepath.Join(st.Path, "2024-01-01.jsonl"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-01-01T12:02:00Z","serialNum`)
+	f.Close()
+	list, err := st.Query("a", "", time.Time{}, time.Time{})
+	if err != nil || len(list) != 2 {
+		t.Errorf("Query with a torn last line = %d record(s), %v, want 2, nil", len(list), err)
+	}
+	// a write after the crash lands on a line of its own
+	if err = st.Write(status("a", t0.Add(3*time.Minute), true)); err != nil {
+		t.Fatal(err)
+	}
+	list, err = st.Query("a", "", time.Time{}, time.Time{})
+	if err != nil || len(list) != 3 {
+		t.Errorf("Query after a write = %d record(s), %v, want 3, nil", len(list), err)
+	}
+}
+
+func TestStoreTornLineMidFile(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := testStore(t)
+	data := `{"time":"2024-01-01T12:00:00Z","serialNumber":"a","kind":"status","connected":true}
+{"time":"2024-01-01T12:01:00Z","serialNum{"time":"2024-01-01T12:02:00Z","serialNumber":"a","kind":"status"}
+{"time":"2024-01-01T12:03:00Z","serialNumber":"a","kind":"status","connected":true}
+`
+	if err := ioutil.WriteFile(filepath.Join(st.Path, "2024-01-01.jsonl"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := st.Query("a", "", t0, t0.Add(time.Hour))
+	if err != nil || len(list) != 2 {
+		t.Errorf("Query with a torn line mid-file = %d record(s), %v, want 2, nil", len(list), err)
+	}
+	if st.Skipped() != 1 {
+		t.Errorf("Skipped = %d, want 1", st.Skipped())
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	st := testStore(t, status("a", day(1), true), status("a", day(1), true), status("a", day(2), true), status("a", day(3), true))
+	n, err := st.Compact(day(3))
+	if err != nil || n != 3 {
+		t.Errorf("Compact = %d, %v, want 3, nil", n, err)
+	}
+	list, _ := st.Query("", "", time.Time{}, time.Time{})
+	if len(list) != 1 || !list[0].Time.Equal(day(3)) {
+		t.Errorf("Compact left %d record(s), want the one of day 3", len(list))
+	}
+}
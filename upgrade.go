@@ -0,0 +1,241 @@
+package tipWifi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrReconnectTimeout is returned when a device did not reconnect at all before
+// the timeout, which leaves an upgrade unverified rather than failed.
+var ErrReconnectTimeout = errors.New("Timed out waiting for reconnect")
+
+// ErrUpgradeNotApplied is returned when a device reconnected on the revision it
+// was upgraded from, which leaves nothing to roll back.
+var ErrUpgradeNotApplied = errors.New("Upgrade not applied")
+
+// The VerifyOptions object controls how UpgradeAndVerify waits for and judges a device
+// after the upgrade request has been sent.
+type VerifyOptions struct {
+	ExpectRevision string        // revision the device must report, looked up from the FMS by URI if empty
+	Timeout        time.Duration // how long to wait for the device to reconnect, def: 10m
+	PollInterval   time.Duration // how often to query the FMS, def: 15s
+	MinSanity      int           // minimum HealthCheck Sanity once reconnected, 0 to skip
+	Rollback       bool          // re-flash the previous image if verification fails
+}
+
+// Duration returns the longest UpgradeAndVerify may wait on a device: one
+// Timeout to verify the upgrade, and another to verify a rollback.
+func (opts *VerifyOptions) Duration() time.Duration {
+	d := opts.Timeout
+	if d <= 0 {
+		d = defaultPollTimeout
+	}
+	if opts.Rollback {
+		d *= 2
+	}
+	return d
+}
+
+// The UpgradeResult object records the outcome of an UpgradeAndVerify call.
+type UpgradeResult struct {
+	SerialNumber     string
+	PreviousRevision string
+	PreviousURI      string
+	TargetURI        string
+	ExpectRevision   string
+	Revision         string
+	Sanity           int
+	Verified         bool
+	RolledBack       bool
+	Error            string
+}
+
+// GenerateDescription returns a string of concatenated values describing the UpgradeResult object.
+func (ur *UpgradeResult) GenerateDescription() string {
+	desc := fmt.Sprintf("Previous: %s, ", ur.PreviousRevision)
+	desc += fmt.Sprintf("Expected: %s, ", ur.ExpectRevision)
+	desc += fmt.Sprintf("Current: %s, ", ur.Revision)
+	desc += fmt.Sprintf("Verified: %t, Rolled Back: %t, ", ur.Verified, ur.RolledBack)
+	if ur.Error != "" {
+		desc += fmt.Sprintf("Error: %s, ", ur.Error)
+	}
+	return desc
+}
+
+// Report returns the UpgradeResult as a single row Report.
+func (ur *UpgradeResult) Report() *Report {
+	r := NewReport("Upgrade", "serialNumber", "previousRevision", "expectRevision", "revision", "sanity", "verified", "rolledBack", "error")
+	r.Add(ur.SerialNumber, ur.PreviousRevision, ur.ExpectRevision, ur.Revision, ur.Sanity, ur.Verified, ur.RolledBack, ur.Error)
+	return r
+}
+
+// UpgradeFailedEvent returns the EventUpgradeFailed Event of a device that did
+// not upgrade from one revision to the other.
+func UpgradeFailedEvent(sn, devType, from, to string) *Event {
+	return &Event{
+		Type:         EventUpgradeFailed,
+		SerialNumber: sn,
+		DeviceType:   devType,
+		From:         from,
+		To:           to,
+		Time:         time.Now(),
+	}
+}
+
+// UpgradeAndVerify upgrades the device to the supplied URI and then polls the FMS
+// until the device has reconnected on the expected revision. The upgrade is
+// refused or deferred with a PolicyError when policy disallows it. The previous revision
+// and its URI are recorded first so that, with Rollback set, a device which comes
+// back unhealthy or on the wrong version is re-flashed with its previous image.
+// A device that never reconnects is reported unverified with ErrReconnectTimeout
+// and is not rolled back, as there is nothing to tell it failed, and neither is
+// one that reconnects on its previous revision, with ErrUpgradeNotApplied.
+func (uc *UCentral) UpgradeAndVerify(sn, uri string, opts *VerifyOptions) (*UpgradeResult, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	fwd, err := uc.GetFirmwareDevice(sn)
+	if err != nil {
+		return nil, err
+	}
+	ur := &UpgradeResult{
+		SerialNumber:     sn,
+		PreviousRevision: fwd.Revision,
+		TargetURI:        uri,
+		ExpectRevision:   opts.ExpectRevision,
+	}
+	fw := &Firmware{DeviceType: fwd.DeviceType, URI: uri, Revision: opts.ExpectRevision}
+	fws, err := uc.GetFirmwareListByDevice(fwd.DeviceType)
+	if err == nil {
+		if prev := fws.FindByRevision(fwd.Revision); prev != nil {
+			ur.PreviousURI = prev.URI
+		}
+		if next := fws.FindByURI(uri); next != nil {
+			fw = next
+			if ur.ExpectRevision == "" {
+				ur.ExpectRevision = next.Revision
+			}
+		}
+	}
+	if ur.ExpectRevision != "" && ur.ExpectRevision == ur.PreviousRevision {
+		return ur, errors.New("Target Revision same as Current Version!")
+	}
+	err = uc.CheckUpgradePolicy(sn, fw)
+	if err != nil {
+		ur.Error = err.Error()
+		return ur, err
+	}
+
+	err = uc.UpgradeDeviceFirmware(sn, uri)
+	if err != nil {
+		ur.Error = err.Error()
+		return ur, err
+	}
+	err = uc.verifyUpgrade(ur, ur.ExpectRevision, opts)
+	if err == nil {
+		ur.Verified = true
+		return ur, nil
+	}
+	if ur.Revision != "" && ur.Revision == ur.PreviousRevision {
+		err = ErrUpgradeNotApplied
+	}
+	ur.Error = err.Error()
+	if !opts.Rollback || err == ErrReconnectTimeout || err == ErrUpgradeNotApplied {
+		return ur, err
+	}
+	if ur.PreviousURI == "" {
+		return ur, fmt.Errorf("%s, no previous image available for rollback", err)
+	}
+	log.Printf("%s: %s, rolling back to %s\n", sn, err, ur.PreviousRevision)
+	err = uc.UpgradeDeviceFirmware(sn, ur.PreviousURI)
+	if err != nil {
+		return ur, fmt.Errorf("%s, rollback failed: %s", ur.Error, err)
+	}
+	rb := &VerifyOptions{
+		Timeout:      opts.Timeout,
+		PollInterval: opts.PollInterval,
+	}
+	err = uc.verifyUpgrade(ur, ur.PreviousRevision, rb)
+	if err != nil {
+		return ur, fmt.Errorf("%s, rollback failed: %s", ur.Error, err)
+	}
+	ur.RolledBack = true
+	return ur, errors.New(ur.Error)
+}
+
+// verifyUpgrade waits for the device to reconnect on the expected revision, or on
+// any revision other than the previous one when no revision is expected, and then
+// checks its health.
+func (uc *UCentral) verifyUpgrade(ur *UpgradeResult, expect string, opts *VerifyOptions) error {
+	fwd, err := uc.WaitForRevision(ur.SerialNumber, func(rev string) bool {
+		if expect != "" {
+			return rev == expect
+		}
+		return rev != ur.PreviousRevision
+	}, opts.Timeout, opts.PollInterval)
+	if fwd != nil {
+		ur.Revision = fwd.Revision
+	}
+	if err != nil {
+		return err
+	}
+	if opts.MinSanity > 0 {
+		hc, err := uc.GetDeviceHealthCheck(ur.SerialNumber)
+		if err != nil {
+			return err
+		}
+		ur.Sanity = hc.Sanity
+		if hc.Sanity < opts.MinSanity {
+			return fmt.Errorf("Sanity %d below %d", hc.Sanity, opts.MinSanity)
+		}
+	}
+	return nil
+}
+
+// WaitForRevision polls the FMS until the device is connected and its revision
+// satisfies the match function, or the timeout passes. The last FirmwareDevice
+// seen is returned in either case.
+func (uc *UCentral) WaitForRevision(sn string, match func(string) bool, timeout, interval time.Duration) (*FirmwareDevice, error) {
+	var last *FirmwareDevice
+	ok := pollUntil(timeout, interval, func() bool {
+		fwd, err := uc.GetFirmwareDevice(sn)
+		if err != nil {
+			return false
+		}
+		last = fwd
+		return fwd.IsConnected() && match(fwd.Revision)
+	})
+	switch {
+	case ok:
+		return last, nil
+	case last != nil && last.IsConnected():
+		return last, fmt.Errorf("Device reconnected on unexpected revision %s", last.Revision)
+	}
+	return last, ErrReconnectTimeout
+}
+
+// defaultPollTimeout is how long pollUntil waits when no timeout is supplied.
+const defaultPollTimeout = 10 * time.Minute
+
+// pollUntil calls poll every interval until it returns true, reporting false
+// once the timeout has passed without it doing so.
+func pollUntil(timeout, interval time.Duration, poll func() bool) bool {
+	if timeout <= 0 {
+		timeout = defaultPollTimeout
+	}
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if poll() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,235 @@
+package tipWifi
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the handshake key by the server, RFC 6455 section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Websocket opcodes, RFC 6455 section 5.2.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsMaxMessage bounds the size of a reassembled message.
+const wsMaxMessage = 16 << 20
+
+// The wsConn object is the client side of a websocket connection. Reads must
+// come from a single goroutine, writes may come from any.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	wmu  sync.Mutex
+}
+
+// dialWebsocket opens a websocket to the supplied host and path over TLS,
+// trusting the same certificates as HTTPClient.
+func dialWebsocket(host, path string, timeout time.Duration) (*wsConn, error) {
+	if !strings.Contains(host, ":") {
+		host += ":443"
+	}
+	cfg := &tls.Config{}
+	if t, ok := HTTPClient.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		cfg = t.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host[:strings.LastIndex(host, ":")]
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", host, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ws := &wsConn{conn: conn, br: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(timeout))
+	err = ws.handshake(host, path)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+func (ws *wsConn) handshake(host, path string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s%s", host, path), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if Debug {
+		fmt.Printf("|-| GET wss://%s%s |-|\n", host, path)
+	}
+	if err = req.Write(ws.conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(ws.br, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if Debug {
+		fmt.Printf("|+| %s |+|\n", resp.Status)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return errors.New(resp.Status)
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return errors.New("Invalid Websocket Handshake")
+	}
+	return nil
+}
+
+// writeFrame sends a single masked frame, as required of a client.
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, 0x80|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame := append(header, mask...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// WriteText sends a text message.
+func (ws *wsConn) WriteText(msg string) error {
+	return ws.writeFrame(wsText, []byte(msg))
+}
+
+// Ping sends a ping, the reply is consumed by ReadMessage.
+func (ws *wsConn) Ping() error {
+	return ws.writeFrame(wsPing, nil)
+}
+
+// ReadMessage returns the next text or binary message, reassembling fragments
+// and answering pings. A close frame is answered and returned as io.EOF.
+func (ws *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err = ws.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			ws.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > wsMaxMessage {
+				return nil, errors.New("Websocket Message Too Large")
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("Unknown Websocket Opcode %d", opcode)
+		}
+	}
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		err = errors.New("Websocket Message Too Large")
+		return
+	}
+	var mask [4]byte
+	masked := head[1]&0x80 != 0
+	if masked {
+		if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := 0; i < len(payload); i++ {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// SetReadDeadline bounds the wait for the next frame.
+func (ws *wsConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+// Close sends a normal closure and closes the connection.
+func (ws *wsConn) Close() error {
+	ws.writeFrame(wsClose, []byte{0x03, 0xE8})
+	return ws.conn.Close()
+}